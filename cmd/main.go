@@ -18,15 +18,19 @@ func main () {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
+	stopped := make(chan struct{})
 	go func () {
 		<-c
 		log.Println("Shutting down gracefully....")
-		srv.Shutdown()
-		os.Exit(0)
+		if err := srv.Shutdown(); err != nil {
+			log.Printf("shutdown finished with error: %v", err)
+		}
+		close(stopped)
 	}()
 
 	log.Printf("starting MT5 websocket server on port %s", cfg.Port)
 	if err := srv.Start(); err != nil{
 		log.Fatal("failed to start server", err)
 	}
+	<-stopped
 }
\ No newline at end of file
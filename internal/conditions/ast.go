@@ -0,0 +1,432 @@
+package conditions
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Env interface {
+	Lookup(name string) (Value, bool)
+	Call(name string, args []Value) (Value, error)
+}
+
+type Func struct {
+	Params  []Kind
+	MinArgs int
+	Result  Kind
+}
+
+type Schema struct {
+	Fields   map[string]Kind
+	Prefixes map[string]Kind
+	Funcs    map[string]Func
+}
+
+func (s *Schema) fieldKind(name string) (Kind, bool) {
+	if kind, ok := s.Fields[name]; ok {
+		return kind, true
+	}
+	for prefix, kind := range s.Prefixes {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return kind, true
+		}
+	}
+	return KindNull, false
+}
+
+type Expr interface {
+	Eval(env Env) (Value, error)
+	String() string
+}
+
+// Node is an expression node defined outside this package. Check trusts
+// its ResultKind.
+type Node interface {
+	Expr
+	ResultKind() Kind
+}
+
+type Literal struct {
+	Value Value
+}
+
+type Field struct {
+	Name string
+}
+
+type Call struct {
+	Name string
+	Args []Expr
+}
+
+type Not struct {
+	X Expr
+}
+
+type Neg struct {
+	X Expr
+}
+
+type Logical struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type Compare struct {
+	Op    string
+	Left  Expr
+	Right Expr
+}
+
+type In struct {
+	X      Expr
+	Set    []Expr
+	Negate bool
+}
+
+func (l *Literal) Eval(env Env) (Value, error) {
+	return l.Value, nil
+}
+
+func (l *Literal) String() string {
+	return l.Value.String()
+}
+
+func (f *Field) Eval(env Env) (Value, error) {
+	if v, ok := env.Lookup(f.Name); ok {
+		return v, nil
+	}
+	return Null(), nil
+}
+
+func (f *Field) String() string {
+	return f.Name
+}
+
+func (c *Call) Eval(env Env) (Value, error) {
+	args := make([]Value, len(c.Args))
+	for i, arg := range c.Args {
+		v, err := arg.Eval(env)
+		if err != nil {
+			return Null(), err
+		}
+		args[i] = v
+	}
+	return env.Call(c.Name, args)
+}
+
+func (c *Call) String() string {
+	args := make([]string, len(c.Args))
+	for i, arg := range c.Args {
+		args[i] = arg.String()
+	}
+	return c.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+func (n *Not) Eval(env Env) (Value, error) {
+	v, err := n.X.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	if v.Kind != KindBool {
+		return Null(), fmt.Errorf("NOT expects bool, got %s", v.Kind)
+	}
+	return Bool(!v.Bool), nil
+}
+
+func (n *Not) String() string {
+	return "NOT " + n.X.String()
+}
+
+func (n *Neg) Eval(env Env) (Value, error) {
+	v, err := n.X.Eval(env)
+	if err != nil || v.Kind == KindNull {
+		return v, err
+	}
+	if v.Kind != KindNumber {
+		return Null(), fmt.Errorf("unary - expects number, got %s", v.Kind)
+	}
+	return Number(-v.Num), nil
+}
+
+func (n *Neg) String() string {
+	return "-" + n.X.String()
+}
+
+func (l *Logical) Eval(env Env) (Value, error) {
+	left, err := l.Left.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	if left.Kind != KindBool {
+		return Null(), fmt.Errorf("%s expects bool operands, got %s", l.Op, left.Kind)
+	}
+	if l.Op == "AND" && !left.Bool {
+		return Bool(false), nil
+	}
+	if l.Op == "OR" && left.Bool {
+		return Bool(true), nil
+	}
+
+	right, err := l.Right.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	if right.Kind != KindBool {
+		return Null(), fmt.Errorf("%s expects bool operands, got %s", l.Op, right.Kind)
+	}
+	return Bool(right.Bool), nil
+}
+
+func (l *Logical) String() string {
+	return "(" + l.Left.String() + " " + l.Op + " " + l.Right.String() + ")"
+}
+
+func (c *Compare) Eval(env Env) (Value, error) {
+	left, err := c.Left.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	right, err := c.Right.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	return compareValues(c.Op, left, right)
+}
+
+func (c *Compare) String() string {
+	return c.Left.String() + " " + c.Op + " " + c.Right.String()
+}
+
+func (n *In) Eval(env Env) (Value, error) {
+	x, err := n.X.Eval(env)
+	if err != nil {
+		return Null(), err
+	}
+	for _, item := range n.Set {
+		v, err := item.Eval(env)
+		if err != nil {
+			return Null(), err
+		}
+		eq, err := compareValues("==", x, v)
+		if err != nil {
+			return Null(), err
+		}
+		if eq.Bool {
+			return Bool(!n.Negate), nil
+		}
+	}
+	return Bool(n.Negate), nil
+}
+
+func (n *In) String() string {
+	items := make([]string, len(n.Set))
+	for i, item := range n.Set {
+		items[i] = item.String()
+	}
+	op := " IN "
+	if n.Negate {
+		op = " NOT IN "
+	}
+	return n.X.String() + op + "(" + strings.Join(items, ", ") + ")"
+}
+
+// A null only equals another null, and != is always the negation of ==, so
+// a missing value is != "v" and NOT IN any set without null. Ordering
+// comparisons against null are false.
+func compareValues(op string, left, right Value) (Value, error) {
+	if left.Kind == KindNull || right.Kind == KindNull {
+		equal := left.Kind == right.Kind
+		switch op {
+		case "==":
+			return Bool(equal), nil
+		case "!=":
+			return Bool(!equal), nil
+		}
+		return Bool(false), nil
+	}
+	if left.Kind != right.Kind {
+		return Null(), fmt.Errorf("cannot compare %s with %s", left.Kind, right.Kind)
+	}
+
+	switch left.Kind {
+	case KindNumber:
+		return Bool(compareOrdered(op, left.Num, right.Num)), nil
+	case KindString:
+		return Bool(compareOrdered(op, left.Str, right.Str)), nil
+	case KindBool:
+		switch op {
+		case "==":
+			return Bool(left.Bool == right.Bool), nil
+		case "!=":
+			return Bool(left.Bool != right.Bool), nil
+		}
+		return Null(), fmt.Errorf("operator %s not defined on bool", op)
+	}
+	return Bool(false), nil
+}
+
+func compareOrdered[T float64 | string](op string, a, b T) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func Fields(e Expr) []string {
+	seen := map[string]bool{}
+	names := []string{}
+	Walk(e, func(n Expr) {
+		if f, ok := n.(*Field); ok && !seen[f.Name] {
+			seen[f.Name] = true
+			names = append(names, f.Name)
+		}
+	})
+	return names
+}
+
+func Walk(e Expr, fn func(Expr)) {
+	fn(e)
+	switch n := e.(type) {
+	case *Call:
+		for _, arg := range n.Args {
+			Walk(arg, fn)
+		}
+	case *Not:
+		Walk(n.X, fn)
+	case *Neg:
+		Walk(n.X, fn)
+	case *Logical:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *Compare:
+		Walk(n.Left, fn)
+		Walk(n.Right, fn)
+	case *In:
+		Walk(n.X, fn)
+		for _, item := range n.Set {
+			Walk(item, fn)
+		}
+	}
+}
+
+func Check(e Expr, schema *Schema) error {
+	kind, err := check(e, schema)
+	if err != nil {
+		return err
+	}
+	if kind != KindBool {
+		return fmt.Errorf("condition must be a boolean expression, got %s", kind)
+	}
+	return nil
+}
+
+func check(e Expr, schema *Schema) (Kind, error) {
+	switch n := e.(type) {
+	case *Literal:
+		return n.Value.Kind, nil
+	case *Field:
+		kind, ok := schema.fieldKind(n.Name)
+		if !ok {
+			return KindNull, fmt.Errorf("unknown field %q", n.Name)
+		}
+		return kind, nil
+	case *Call:
+		fn, ok := schema.Funcs[n.Name]
+		if !ok {
+			return KindNull, fmt.Errorf("unknown function %q", n.Name)
+		}
+		if len(n.Args) < fn.MinArgs || len(n.Args) > len(fn.Params) {
+			return KindNull, fmt.Errorf("%s expects %d to %d arguments, got %d", n.Name, fn.MinArgs, len(fn.Params), len(n.Args))
+		}
+		for i, arg := range n.Args {
+			kind, err := check(arg, schema)
+			if err != nil {
+				return KindNull, err
+			}
+			if kind != fn.Params[i] {
+				return KindNull, fmt.Errorf("argument %d of %s must be %s, got %s", i+1, n.Name, fn.Params[i], kind)
+			}
+		}
+		return fn.Result, nil
+	case *Not:
+		kind, err := check(n.X, schema)
+		if err != nil {
+			return KindNull, err
+		}
+		if kind != KindBool {
+			return KindNull, fmt.Errorf("NOT expects bool, got %s in %s", kind, n.X)
+		}
+		return KindBool, nil
+	case *Neg:
+		kind, err := check(n.X, schema)
+		if err != nil {
+			return KindNull, err
+		}
+		if kind != KindNumber && kind != KindNull {
+			return KindNull, fmt.Errorf("unary - expects number, got %s in %s", kind, n.X)
+		}
+		return KindNumber, nil
+	case *Logical:
+		for _, side := range []Expr{n.Left, n.Right} {
+			kind, err := check(side, schema)
+			if err != nil {
+				return KindNull, err
+			}
+			if kind != KindBool {
+				return KindNull, fmt.Errorf("%s expects bool operands, got %s in %s", n.Op, kind, side)
+			}
+		}
+		return KindBool, nil
+	case *Compare:
+		left, err := check(n.Left, schema)
+		if err != nil {
+			return KindNull, err
+		}
+		right, err := check(n.Right, schema)
+		if err != nil {
+			return KindNull, err
+		}
+		if left != right && left != KindNull && right != KindNull {
+			return KindNull, fmt.Errorf("cannot compare %s with %s in %s", left, right, n)
+		}
+		if left == KindBool && n.Op != "==" && n.Op != "!=" {
+			return KindNull, fmt.Errorf("operator %s not defined on bool in %s", n.Op, n)
+		}
+		return KindBool, nil
+	case *In:
+		kind, err := check(n.X, schema)
+		if err != nil {
+			return KindNull, err
+		}
+		if kind == KindBool {
+			return KindNull, fmt.Errorf("IN not defined on bool in %s", n)
+		}
+		for _, item := range n.Set {
+			itemKind, err := check(item, schema)
+			if err != nil {
+				return KindNull, err
+			}
+			if itemKind != kind && itemKind != KindNull && kind != KindNull {
+				return KindNull, fmt.Errorf("cannot compare %s with %s in %s", kind, itemKind, n)
+			}
+		}
+		return KindBool, nil
+	}
+	if n, ok := e.(Node); ok {
+		return n.ResultKind(), nil
+	}
+	return KindNull, fmt.Errorf("unsupported expression %s", strings.TrimSpace(e.String()))
+}
@@ -0,0 +1,134 @@
+package conditions
+
+type Clause struct {
+	Condition string
+	Subject   string
+	Operator  string
+	Observed  Value
+	Threshold Value
+}
+
+type Explainer interface {
+	Explain(env Env) (Clause, bool, error)
+}
+
+func Explain(e Expr, env Env) ([]Clause, error) {
+	var clauses []Clause
+	if _, err := explain(e, env, &clauses); err != nil {
+		return nil, err
+	}
+	return clauses, nil
+}
+
+func explain(e Expr, env Env, clauses *[]Clause) (bool, error) {
+	switch n := e.(type) {
+	case Explainer:
+		clause, ok, err := n.Explain(env)
+		if err != nil || !ok {
+			return false, err
+		}
+		*clauses = append(*clauses, clause)
+		return true, nil
+	case *Logical:
+		var left []Clause
+		ok, err := explain(n.Left, env, &left)
+		if err != nil {
+			return false, err
+		}
+		if n.Op == "OR" && ok {
+			*clauses = append(*clauses, left...)
+			return true, nil
+		}
+		if n.Op == "AND" && !ok {
+			return false, nil
+		}
+
+		var right []Clause
+		ok, err = explain(n.Right, env, &right)
+		if err != nil || !ok {
+			return false, err
+		}
+		if n.Op == "AND" {
+			*clauses = append(*clauses, left...)
+		}
+		*clauses = append(*clauses, right...)
+		return true, nil
+	case *Compare:
+		left, err := n.Left.Eval(env)
+		if err != nil {
+			return false, err
+		}
+		right, err := n.Right.Eval(env)
+		if err != nil {
+			return false, err
+		}
+		result, err := compareValues(n.Op, left, right)
+		if err != nil || !result.Bool {
+			return false, err
+		}
+		*clauses = append(*clauses, Clause{
+			Condition: n.String(),
+			Subject:   n.Left.String(),
+			Operator:  n.Op,
+			Observed:  left,
+			Threshold: right,
+		})
+		return true, nil
+	case *Not:
+		inner, ok := n.X.(*Compare)
+		if !ok {
+			break
+		}
+		result, err := n.Eval(env)
+		if err != nil || !result.Bool {
+			return false, err
+		}
+		left, err := inner.Left.Eval(env)
+		if err != nil {
+			return false, err
+		}
+		right, err := inner.Right.Eval(env)
+		if err != nil {
+			return false, err
+		}
+		*clauses = append(*clauses, Clause{
+			Condition: n.String(),
+			Subject:   inner.Left.String(),
+			Operator:  "NOT " + inner.Op,
+			Observed:  left,
+			Threshold: right,
+		})
+		return true, nil
+	case *In:
+		result, err := n.Eval(env)
+		if err != nil || !result.Bool {
+			return false, err
+		}
+		x, err := n.X.Eval(env)
+		if err != nil {
+			return false, err
+		}
+		op := "IN"
+		if n.Negate {
+			op = "NOT IN"
+		}
+		*clauses = append(*clauses, Clause{
+			Condition: n.String(),
+			Subject:   n.X.String(),
+			Operator:  op,
+			Observed:  x,
+		})
+		return true, nil
+	}
+
+	result, err := e.Eval(env)
+	if err != nil || result.Kind != KindBool || !result.Bool {
+		return false, err
+	}
+	*clauses = append(*clauses, Clause{
+		Condition: e.String(),
+		Subject:   e.String(),
+		Observed:  result,
+	})
+	return true, nil
+}
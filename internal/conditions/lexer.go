@@ -0,0 +1,164 @@
+package conditions
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokLParen
+	tokRParen
+	tokComma
+	tokMinus
+	tokCompare
+	tokAnd
+	tokOr
+	tokNot
+	tokIn
+	tokTrue
+	tokFalse
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+var keywords = map[string]tokenKind{
+	"and":   tokAnd,
+	"or":    tokOr,
+	"not":   tokNot,
+	"in":    tokIn,
+	"true":  tokTrue,
+	"false": tokFalse,
+}
+
+func lex(src string) ([]token, error) {
+	tokens := []token{}
+	runes := []rune(src)
+	i := 0
+
+	for i < len(runes) {
+		r := runes[i]
+		start := i
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", start})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", start})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", start})
+			i++
+		case r == '-':
+			tokens = append(tokens, token{tokMinus, "-", start})
+			i++
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, &SyntaxError{start, fmt.Sprintf("unexpected %q", r)}
+			}
+			kind := tokAnd
+			if r == '|' {
+				kind = tokOr
+			}
+			tokens = append(tokens, token{kind, string(runes[i : i+2]), start})
+			i += 2
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, token{tokCompare, string(runes[i : i+2]), start})
+				i += 2
+				continue
+			}
+			switch r {
+			case '!':
+				tokens = append(tokens, token{tokNot, "!", start})
+			case '<', '>':
+				tokens = append(tokens, token{tokCompare, string(r), start})
+			default:
+				return nil, &SyntaxError{start, "expected '==' but found '='"}
+			}
+			i++
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(runes) {
+				c := runes[i]
+				if c == '\\' && i+1 < len(runes) {
+					switch runes[i+1] {
+					case 'n':
+						sb.WriteRune('\n')
+					case 't':
+						sb.WriteRune('\t')
+					default:
+						sb.WriteRune(runes[i+1])
+					}
+					i += 2
+					continue
+				}
+				if c == r {
+					closed = true
+					i++
+					break
+				}
+				sb.WriteRune(c)
+				i++
+			}
+			if !closed {
+				return nil, &SyntaxError{start, "unterminated string literal"}
+			}
+			tokens = append(tokens, token{tokString, sb.String(), start})
+		case unicode.IsDigit(r) || (r == '.' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, string(runes[start:i]), start})
+		case unicode.IsLetter(r) || r == '_':
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_' || runes[i] == '.') {
+				i++
+			}
+			text := string(runes[start:i])
+			if kind, ok := keywords[strings.ToLower(text)]; ok {
+				tokens = append(tokens, token{kind, text, start})
+			} else {
+				tokens = append(tokens, token{tokIdent, text, start})
+			}
+		default:
+			return nil, &SyntaxError{start, fmt.Sprintf("unexpected character %q", r)}
+		}
+	}
+
+	tokens = append(tokens, token{tokEOF, "", len(runes)})
+	return tokens, nil
+}
+
+var quoteReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// Quote renders s as a double-quoted string literal the lexer reads back
+// unchanged. Only backslashes and double quotes are escaped: strconv.Quote
+// also emits \x and \u escapes for unprintable runes, which the lexer does
+// not decode.
+func Quote(s string) string {
+	return `"` + quoteReplacer.Replace(s) + `"`
+}
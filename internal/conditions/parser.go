@@ -0,0 +1,199 @@
+package conditions
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func Parse(src string) (Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, &SyntaxError{tok.pos, fmt.Sprintf("unexpected %q", tok.text)}
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokEOF {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokOr {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().kind == tokAnd {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &Logical{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.peek().kind == tokNot {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *parser) parseCompare() (Expr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind == tokIn || (p.peek().kind == tokNot && p.tokens[p.pos+1].kind == tokIn) {
+		return p.parseIn(left)
+	}
+	if p.peek().kind == tokCompare {
+		op := p.next().text
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &Compare{Op: op, Left: left, Right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseIn(x Expr) (Expr, error) {
+	in := &In{X: x}
+	if p.next().kind == tokNot {
+		in.Negate = true
+		p.next()
+	}
+
+	if open := p.next(); open.kind != tokLParen {
+		return nil, &SyntaxError{open.pos, "expected '(' after IN"}
+	}
+	for {
+		item, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		in.Set = append(in.Set, item)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return in, nil
+		}
+		if tok.kind != tokComma {
+			return nil, &SyntaxError{tok.pos, "expected ',' or ')' in IN list"}
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokNumber:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, &SyntaxError{tok.pos, fmt.Sprintf("invalid number %q", tok.text)}
+		}
+		return &Literal{Value: Number(n)}, nil
+	case tokString:
+		return &Literal{Value: String(tok.text)}, nil
+	case tokTrue:
+		return &Literal{Value: Bool(true)}, nil
+	case tokFalse:
+		return &Literal{Value: Bool(false)}, nil
+	case tokMinus:
+		x, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		if lit, ok := x.(*Literal); ok && lit.Value.Kind == KindNumber {
+			return &Literal{Value: Number(-lit.Value.Num)}, nil
+		}
+		return &Neg{X: x}, nil
+	case tokIdent:
+		if p.peek().kind == tokLParen {
+			p.next()
+			return p.parseCall(tok)
+		}
+		return &Field{Name: tok.text}, nil
+	case tokLParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, &SyntaxError{closing.pos, "expected ')'"}
+		}
+		return expr, nil
+	case tokEOF:
+		return nil, &SyntaxError{tok.pos, "unexpected end of expression"}
+	}
+	return nil, &SyntaxError{tok.pos, fmt.Sprintf("unexpected %q", tok.text)}
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	call := &Call{Name: name.text}
+	if p.peek().kind == tokRParen {
+		p.next()
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+
+		tok := p.next()
+		if tok.kind == tokRParen {
+			return call, nil
+		}
+		if tok.kind != tokComma {
+			return nil, &SyntaxError{tok.pos, "expected ',' or ')' in call to " + name.text}
+		}
+	}
+}
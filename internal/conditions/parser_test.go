@@ -0,0 +1,192 @@
+package conditions
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type mapEnv map[string]Value
+
+func (e mapEnv) Lookup(name string) (Value, bool) {
+	v, ok := e[name]
+	if !ok {
+		return Null(), true
+	}
+	return v, true
+}
+
+func (e mapEnv) Call(name string, args []Value) (Value, error) {
+	return Null(), errors.New("no functions in test env")
+}
+
+var testSchema = &Schema{
+	Fields: map[string]Kind{
+		"a":      KindBool,
+		"b":      KindBool,
+		"c":      KindBool,
+		"volume": KindNumber,
+		"symbol": KindString,
+	},
+	Prefixes: map[string]Kind{"custom_data.": KindString},
+}
+
+var testEnv = mapEnv{
+	"a":      Bool(true),
+	"b":      Bool(false),
+	"c":      Bool(false),
+	"volume": Number(-2.5),
+	"symbol": String("EURUSD"),
+}
+
+func TestParsePrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"a or b and c", "(a OR (b AND c))"},
+		{"a and b or c", "((a AND b) OR c)"},
+		{"(a or b) and c", "((a OR b) AND c)"},
+		{"not a and b", "(NOT a AND b)"},
+		{"not (a and b)", "NOT (a AND b)"},
+		{"a && b || !c", "((a AND b) OR NOT c)"},
+		{"volume > 1 and symbol == 'X'", "(volume > 1 AND symbol == \"X\")"},
+		{"volume < -5", "volume < -5"},
+		{"volume >= -.5", "volume >= -0.5"},
+		{"-volume > 1", "-volume > 1"},
+		{"symbol in ('EURUSD', \"GBPUSD\")", "symbol IN (\"EURUSD\", \"GBPUSD\")"},
+		{"symbol NOT IN ('X')", "symbol NOT IN (\"X\")"},
+		{"not symbol in ('X')", "NOT symbol IN (\"X\")"},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.src, err)
+			continue
+		}
+		if got := expr.String(); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"a or b and c", true},
+		{"(a or b) and c", false},
+		{"not b", true},
+		{"volume < -2", true},
+		{"volume == -2.5", true},
+		{"-volume > 2", true},
+		{"symbol in ('GBPUSD', 'EURUSD')", true},
+		{"symbol not in ('GBPUSD', 'EURUSD')", false},
+		{"symbol not in ('GBPUSD')", true},
+		{"custom_data.kyc == 'verified'", false},
+		{"custom_data.kyc != 'verified'", true},
+		{"not (custom_data.kyc == 'verified')", true},
+		{"custom_data.kyc in ('verified')", false},
+		{"custom_data.kyc not in ('verified')", true},
+		{"custom_data.kyc < 'z'", false},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.src, err)
+			continue
+		}
+		if err := Check(expr, testSchema); err != nil {
+			t.Errorf("Check(%q) error: %v", tt.src, err)
+			continue
+		}
+		got, err := expr.Eval(testEnv)
+		if err != nil {
+			t.Errorf("Eval(%q) error: %v", tt.src, err)
+			continue
+		}
+		if got.Kind != KindBool || got.Bool != tt.want {
+			t.Errorf("Eval(%q) = %s, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseSyntaxErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		pos int
+		msg string
+	}{
+		{"", 0, "unexpected end of expression"},
+		{"a and", 5, "unexpected end of expression"},
+		{"volume = 1", 7, "expected '=='"},
+		{"symbol == 'EURUSD", 10, "unterminated string literal"},
+		{"(a or b", 7, "expected ')'"},
+		{"a b", 2, `unexpected "b"`},
+		{"symbol in 'X'", 10, "expected '(' after IN"},
+		{"symbol in ('X' 'Y')", 15, "expected ',' or ')' in IN list"},
+		{"a & b", 2, "unexpected '&'"},
+		{"volume > 1 #", 11, "unexpected character '#'"},
+		{"volume - 1 > 0", 7, `unexpected "-"`},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) error = %v, want a SyntaxError", tt.src, err)
+			continue
+		}
+		if syntaxErr.Pos != tt.pos || !strings.Contains(syntaxErr.Msg, tt.msg) {
+			t.Errorf("Parse(%q) error = %v, want %q at position %d", tt.src, err, tt.msg, tt.pos)
+		}
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	tests := []struct {
+		src string
+		msg string
+	}{
+		{"volume", "must be a boolean expression"},
+		{"unknown > 1", `unknown field "unknown"`},
+		{"volume == 'x'", "cannot compare number with string"},
+		{"a > b", "operator > not defined on bool"},
+		{"a in (true)", "IN not defined on bool"},
+		{"symbol in ('X', 1)", "cannot compare string with number"},
+		{"-symbol == 'X'", "unary - expects number"},
+		{"not volume", "NOT expects bool"},
+		{"a and volume", "AND expects bool operands"},
+		{"f(1)", `unknown function "f"`},
+	}
+
+	for _, tt := range tests {
+		expr, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", tt.src, err)
+			continue
+		}
+		err = Check(expr, testSchema)
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("Check(%q) error = %v, want %q", tt.src, err, tt.msg)
+		}
+	}
+}
+
+func TestQuoteRoundTrips(t *testing.T) {
+	for _, s := range []string{"EURUSD", `say "hi"`, `C:\terminal\new`, "tab\tand\x00nul", "zero\u200bwidth"} {
+		src := "symbol == " + Quote(s)
+		expr, err := Parse(src)
+		if err != nil {
+			t.Errorf("Parse(%q) error: %v", src, err)
+			continue
+		}
+		env := mapEnv{"symbol": String(s)}
+		if got, err := expr.Eval(env); err != nil || !got.Bool {
+			t.Errorf("%s did not match %q (%v)", src, s, err)
+		}
+	}
+}
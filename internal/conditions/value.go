@@ -0,0 +1,73 @@
+package conditions
+
+import (
+	"strconv"
+)
+
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindNumber
+	KindString
+	KindBool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNumber:
+		return "number"
+	case KindString:
+		return "string"
+	case KindBool:
+		return "bool"
+	}
+	return "null"
+}
+
+type Value struct {
+	Kind Kind
+	Num  float64
+	Str  string
+	Bool bool
+}
+
+func Number(n float64) Value {
+	return Value{Kind: KindNumber, Num: n}
+}
+
+func String(s string) Value {
+	return Value{Kind: KindString, Str: s}
+}
+
+func Bool(b bool) Value {
+	return Value{Kind: KindBool, Bool: b}
+}
+
+func Null() Value {
+	return Value{Kind: KindNull}
+}
+
+func (v Value) Interface() interface{} {
+	switch v.Kind {
+	case KindNumber:
+		return v.Num
+	case KindString:
+		return v.Str
+	case KindBool:
+		return v.Bool
+	}
+	return nil
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case KindNumber:
+		return strconv.FormatFloat(v.Num, 'f', -1, 64)
+	case KindString:
+		return Quote(v.Str)
+	case KindBool:
+		return strconv.FormatBool(v.Bool)
+	}
+	return "null"
+}
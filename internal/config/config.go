@@ -2,30 +2,68 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"time"
 )
 
 type Config struct {
-	Port 	string
-	RedisAddr 	string
-	RedisPass 	string
-	RedisDB 	int
-	EventBuffer int
-	Workers 	int
+	Port            string
+	RedisAddr       string
+	RedisPass       string
+	RedisDB         int
+	EventBuffer     int
+	Workers         int
+	WindowMaxEvents int
+	WindowRetention int64
+	BrokerTimezone  string
+	BrokerRollover  string
+	RuleRefresh     time.Duration
+	HistoryMaxLen   int64
+	ScheduleEvery   time.Duration
+	RuleBundlePath  string
+	Backpressure    string
+	BackpressureMax time.Duration
+	DedupRetention  time.Duration
+	ShutdownTimeout time.Duration
+	Instance        int
+	Instances       int
 }
 
 func Load() *Config {
 	return &Config{
-		Port: getEnv("PORT", "8080"),
-		RedisAddr: getEnv("REDIS_ADDR", "localhost:6379"),
-		RedisPass: getEnv("REDIS_PASS", ""),
-		RedisDB: 0,
-		EventBuffer: 10000,
-		Workers: 10,	
+		Port:            getEnv("PORT", "8080"),
+		RedisAddr:       getEnv("REDIS_ADDR", "localhost:6379"),
+		RedisPass:       getEnv("REDIS_PASS", ""),
+		RedisDB:         0,
+		EventBuffer:     10000,
+		Workers:         10,
+		WindowMaxEvents: getEnvInt("WINDOW_MAX_EVENTS", 1000),
+		WindowRetention: int64(getEnvInt("WINDOW_RETENTION_SECONDS", 3600)),
+		BrokerTimezone:  getEnv("BROKER_TIMEZONE", "UTC"),
+		BrokerRollover:  getEnv("BROKER_DAY_ROLLOVER", "00:00"),
+		RuleRefresh:     time.Duration(getEnvInt("RULE_REFRESH_SECONDS", 60)) * time.Second,
+		HistoryMaxLen:   int64(getEnvInt("EVENT_HISTORY_MAX_LEN", 1000000)),
+		ScheduleEvery:   time.Duration(getEnvInt("RULE_SCHEDULE_SECONDS", 30)) * time.Second,
+		RuleBundlePath:  getEnv("RULE_BUNDLE_PATH", ""),
+		Backpressure:    getEnv("BACKPRESSURE_POLICY", "block"),
+		BackpressureMax: time.Duration(getEnvInt("BACKPRESSURE_TIMEOUT_MS", 500)) * time.Millisecond,
+		DedupRetention:  time.Duration(getEnvInt("EVENT_DEDUP_SECONDS", 86400)) * time.Second,
+		ShutdownTimeout: time.Duration(getEnvInt("SHUTDOWN_TIMEOUT_SECONDS", 30)) * time.Second,
+		Instance:        getEnvInt("ENGINE_INSTANCE", 0),
+		Instances:       getEnvInt("ENGINE_INSTANCES", 1),
 	}
 }
-	func getEnv(key, defaultValue string) string {
-		if value := os.Getenv(key); value != "" {
-			return value
-		}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
 	return defaultValue
-	}
\ No newline at end of file
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return defaultValue
+}
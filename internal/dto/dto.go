@@ -3,19 +3,61 @@ package dto
 import "github.com/NOTMKW/DLLBEL/internal/models"
 
 type CreateRuleRequest struct {
-	Name       string            `json:"name" validate:"required"`
-	Conditions map[string]string `json:"conditions" validate:"required"`
-	Actions    []models.Action   `json:"actions" validate:"required"`
-	Enabled    bool              `json:"enabled"`
-	Priority   int               `json:"priority"`
+	Name              string            `json:"name" validate:"required"`
+	Conditions        map[string]string `json:"conditions"`
+	Expression        string            `json:"expression"`
+	Actions           []models.Action   `json:"actions" validate:"required"`
+	Enabled           bool              `json:"enabled"`
+	Mode              string            `json:"mode"`
+	Priority          int               `json:"priority"`
+	Final             bool              `json:"final"`
+	SingleAction      bool              `json:"single_action"`
+	CooldownSeconds   int64             `json:"cooldown_seconds"`
+	CooldownPerSymbol bool              `json:"cooldown_per_symbol"`
+	Escalation        []models.Action   `json:"escalation"`
+	ViolationDecay    int64             `json:"violation_decay_seconds"`
+	Trigger           string            `json:"trigger"`
 }
 
 type UpdateRuleRequest struct {
-	Name       string            `json:"name"`
-	Conditions map[string]string `json:"conditions"`
-	Actions    []models.Action   `json:"actions"`
-	Enabled    *bool             `json:"enabled"`
-	Priority   *int              `json:"priority"`
+	Name              string                 `json:"name"`
+	Conditions        map[string]string      `json:"conditions"`
+	Expression        string                 `json:"expression"`
+	Actions           []models.Action        `json:"actions"`
+	Enabled           *bool                  `json:"enabled"`
+	Mode              *string                `json:"mode"`
+	Priority          *int                   `json:"priority"`
+	Final             *bool                  `json:"final"`
+	SingleAction      *bool                  `json:"single_action"`
+	CooldownSeconds   *int64                 `json:"cooldown_seconds"`
+	CooldownPerSymbol *bool                  `json:"cooldown_per_symbol"`
+	Escalation        []models.Action        `json:"escalation"`
+	ViolationDecay    *int64                 `json:"violation_decay_seconds"`
+	Trigger           *string                `json:"trigger"`
+	TemplateParams    map[string]interface{} `json:"template_params"`
+}
+
+type TemplateRequest struct {
+	Name              string                 `json:"name" validate:"required"`
+	Description       string                 `json:"description"`
+	Params            []models.TemplateParam `json:"params"`
+	Expression        string                 `json:"expression" validate:"required"`
+	Actions           []models.Action        `json:"actions" validate:"required"`
+	Priority          int                    `json:"priority"`
+	Final             bool                   `json:"final"`
+	SingleAction      bool                   `json:"single_action"`
+	CooldownSeconds   int64                  `json:"cooldown_seconds"`
+	CooldownPerSymbol bool                   `json:"cooldown_per_symbol"`
+	Escalation        []models.Action        `json:"escalation"`
+	ViolationDecay    int64                  `json:"violation_decay_seconds"`
+	Trigger           string                 `json:"trigger"`
+}
+
+type InstantiateTemplateRequest struct {
+	Name    string                 `json:"name" validate:"required"`
+	Params  map[string]interface{} `json:"params"`
+	Enabled bool                   `json:"enabled"`
+	Mode    string                 `json:"mode"`
 }
 
 type UpdateUserStateRequest struct {
@@ -28,6 +70,12 @@ type UpdateUserStateRequest struct {
 	CustomData     map[string]string `json:"custom_data"`
 }
 
+type UserPositionsResponse struct {
+	UserID    string             `json:"user_id"`
+	Positions []*models.Position `json:"positions"`
+	Exposure  *models.Exposure   `json:"exposure"`
+}
+
 type EnforceRequest struct {
 	Action   string `json:"action" validate:"required"`
 	Reason   string `json:"reason" validate:"required"`
@@ -41,14 +89,59 @@ type ConnectionInfo struct {
 }
 
 type MetricsResponse struct {
-	ActiveDLLConnections int   `json:"active_dll_connections"`
-	WebSocketClients     int   `json:"websocket_clients"`
-	UserStates           int   `json:"user_states"`
-	EventBufferSize      int   `json:"event_buffer_size"`
-	Timestamp            int64 `json:"timestamp"`
+	ActiveDLLConnections   int                `json:"active_dll_connections"`
+	WebSocketClients       int                `json:"websocket_clients"`
+	UserStates             int                `json:"user_states"`
+	EventBufferSize        int                `json:"event_buffer_size"`
+	PartitionDepths        []int              `json:"partition_depths"`
+	Backpressure           *BackpressureStats `json:"backpressure"`
+	DuplicateEvents        int64              `json:"duplicate_events"`
+	DuplicatesBySource     map[string]int64   `json:"duplicates_by_source"`
+	SuppressedEnforcements int64              `json:"suppressed_enforcements"`
+	SuppressedByRule       map[string]int64   `json:"suppressed_by_rule"`
+	ShadowSuppressed       int64              `json:"shadow_suppressed"`
+	ShadowSuppressedByRule map[string]int64   `json:"shadow_suppressed_by_rule"`
+	Timestamp              int64              `json:"timestamp"`
+}
+
+type BackpressureStats struct {
+	Policy         string                      `json:"policy"`
+	TotalDropped   int64                       `json:"total_dropped"`
+	TotalDelayed   int64                       `json:"total_delayed"`
+	Dropped        map[string]map[string]int64 `json:"dropped"`
+	Delayed        map[string]map[string]int64 `json:"delayed"`
+	SlowDownFrames map[string]int64            `json:"slow_down_frames"`
 }
 
 type WSMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
 }
+
+type ShadowQuery struct {
+	RuleID string `query:"rule_id"`
+	UserID string `query:"user_id"`
+	Limit  int    `query:"limit"`
+}
+
+type BacktestRequest struct {
+	Rule    CreateRuleRequest `json:"rule" validate:"required"`
+	From    int64             `json:"from" validate:"required"`
+	To      int64             `json:"to" validate:"required"`
+	UserIDs []string          `json:"user_ids"`
+}
+
+type ReplayRequest struct {
+	From   int64  `json:"from" validate:"required"`
+	To     int64  `json:"to" validate:"required"`
+	UserID string `json:"user_id"`
+}
+
+type DeadLetterRetryRequest struct {
+	Event *models.MT5Event `json:"event"`
+	Raw   []byte           `json:"raw"`
+}
+
+type RollbackRequest struct {
+	Revision int64 `json:"revision" validate:"required"`
+}
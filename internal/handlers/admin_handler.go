@@ -1,12 +1,15 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
 	"github.com/NOTMKW/DLLBEL/internal/services"
 	"github.com/gofiber/fiber/v2"
-	"github.com/NOTMKW/DLLBEL/internal/models"
 )
 
 type AdminHandler struct {
@@ -14,14 +17,26 @@ type AdminHandler struct {
 	wsService   *services.WebSocketService
 	dllService  *services.DLLService
 	userService *services.UserService
+	shadow      *services.ShadowService
+	backtests   *services.BacktestService
+	cooldowns   *services.CooldownService
+	templates   *services.TemplateService
+	events      *services.EventService
+	deadLetters *services.DeadLetterService
 }
 
-func NewAdminHandler(ruleService *services.RuleService, wsService *services.WebSocketService, dllService *services.DLLService, userService *services.UserService) *AdminHandler {
+func NewAdminHandler(ruleService *services.RuleService, wsService *services.WebSocketService, dllService *services.DLLService, userService *services.UserService, shadow *services.ShadowService, backtests *services.BacktestService, cooldowns *services.CooldownService, templates *services.TemplateService, events *services.EventService, deadLetters *services.DeadLetterService) *AdminHandler {
 	return &AdminHandler{
 		ruleService: ruleService,
 		wsService:   wsService,
 		dllService:  dllService,
 		userService: userService,
+		shadow:      shadow,
+		backtests:   backtests,
+		cooldowns:   cooldowns,
+		templates:   templates,
+		events:      events,
+		deadLetters: deadLetters,
 	}
 }
 
@@ -39,9 +54,9 @@ func (h *AdminHandler) CreateRule(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 	}
 
-	rule, err := h.ruleService.CreateRule(&req)
+	rule, err := h.ruleService.CreateRule(&req, author(c))
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return ruleError(c, err)
 	}
 	return c.JSON(rule)
 }
@@ -53,38 +68,334 @@ func (h *AdminHandler) UpdateRule(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 	}
 
-	rule, err := h.ruleService.UpdateRule(id, &req)
+	rule, err := h.ruleService.UpdateRule(id, &req, author(c))
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+		return ruleError(c, err)
 	}
 	return c.JSON(rule)
 }
 
 func (h *AdminHandler) DeleteRule(c *fiber.Ctx) error {
 	id := c.Params("id")
-	
-	h.ruleService.DeleteRule(id)
+
+	if err := h.ruleService.DeleteRule(id, author(c)); err != nil {
+		return ruleError(c, err)
+	}
 	return c.JSON(fiber.Map{"message": "Rule deleted"})
 }
 
+func (h *AdminHandler) GetRuleRevisions(c *fiber.Ctx) error {
+	revisions, err := h.ruleService.GetRevisions(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch revisions"})
+	}
+	return c.JSON(revisions)
+}
+
+func (h *AdminHandler) DiffRuleRevisions(c *fiber.Ctx) error {
+	from, errFrom := strconv.ParseInt(c.Query("from"), 10, 64)
+	to, errTo := strconv.ParseInt(c.Query("to"), 10, 64)
+	if errFrom != nil || errTo != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "from and to revision numbers required"})
+	}
+
+	diffs, err := h.ruleService.DiffRevisions(c.Params("id"), from, to)
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(fiber.Map{"from": from, "to": to, "changes": diffs})
+}
+
+func (h *AdminHandler) RollbackRule(c *fiber.Ctx) error {
+	var req dto.RollbackRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	rule, err := h.ruleService.Rollback(c.Params("id"), req.Revision, author(c))
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(rule)
+}
+
+func (h *AdminHandler) ExportRules(c *fiber.Ctx) error {
+	bundle, err := h.ruleService.ExportBundle()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to export rules"})
+	}
+
+	format := c.Query("format", "json")
+	data, err := services.EncodeBundle(bundle, format)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if format == "yaml" {
+		c.Set(fiber.HeaderContentType, "application/yaml")
+	} else {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+	return c.Send(data)
+}
+
+func (h *AdminHandler) ImportRules(c *fiber.Ctx) error {
+	format := c.Query("format")
+	if format == "" && strings.Contains(c.Get(fiber.HeaderContentType), "yaml") {
+		format = "yaml"
+	}
+
+	bundle, err := services.DecodeBundle(c.Body(), format)
+	if err != nil {
+		return ruleError(c, err)
+	}
+
+	plan, err := h.ruleService.ImportBundle(bundle, c.QueryBool("dry_run"), author(c))
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(plan)
+}
+
+func (h *AdminHandler) GetTemplates(c *fiber.Ctx) error {
+	templates, err := h.templates.GetAllTemplates()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch templates"})
+	}
+	return c.JSON(templates)
+}
+
+func (h *AdminHandler) GetTemplate(c *fiber.Ctx) error {
+	template, err := h.templates.GetTemplate(c.Params("id"))
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(template)
+}
+
+func (h *AdminHandler) CreateTemplate(c *fiber.Ctx) error {
+	var req dto.TemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	template, err := h.templates.CreateTemplate(&req)
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(template)
+}
+
+func (h *AdminHandler) UpdateTemplate(c *fiber.Ctx) error {
+	var req dto.TemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	template, err := h.templates.UpdateTemplate(c.Params("id"), &req, author(c))
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(template)
+}
+
+func (h *AdminHandler) DeleteTemplate(c *fiber.Ctx) error {
+	if err := h.templates.DeleteTemplate(c.Params("id")); err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Template deleted"})
+}
+
+func (h *AdminHandler) InstantiateTemplate(c *fiber.Ctx) error {
+	var req dto.InstantiateTemplateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	rule, err := h.templates.Instantiate(c.Params("id"), &req, author(c))
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.JSON(rule)
+}
+
+func author(c *fiber.Ctx) string {
+	return c.Get("X-Admin-User")
+}
+
+func ruleError(c *fiber.Ctx, err error) error {
+	var verr *services.ValidationError
+	if errors.As(err, &verr) {
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": "validation failed", "problems": verr.Problems})
+	}
+
+	switch {
+	case errors.Is(err, services.ErrInvalidRule):
+		return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrRuleNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
+func (h *AdminHandler) GetShadowEnforcements(c *fiber.Ctx) error {
+	var query dto.ShadowQuery
+	if err := c.QueryParser(&query); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid query"})
+	}
+
+	records, err := h.shadow.List(&query)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch shadow enforcements"})
+	}
+	return c.JSON(records)
+}
+
+func (h *AdminHandler) CreateBacktest(c *fiber.Ctx) error {
+	var req dto.BacktestRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+
+	job, err := h.backtests.Submit(&req)
+	if err != nil {
+		return ruleError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(job)
+}
+
+func (h *AdminHandler) GetBacktests(c *fiber.Ctx) error {
+	return c.JSON(h.backtests.GetJobs())
+}
+
+func (h *AdminHandler) GetBacktest(c *fiber.Ctx) error {
+	job := h.backtests.GetJob(c.Params("id"))
+	if job == nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Backtest not found"})
+	}
+	return c.JSON(job)
+}
+
+func (h *AdminHandler) ReplayEvents(c *fiber.Ctx) error {
+	var req dto.ReplayRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+	}
+	if req.From <= 0 || req.To < req.From {
+		return c.Status(400).JSON(fiber.Map{"error": "from and to must describe a valid time range"})
+	}
+	if id := c.Params("id"); id != "" {
+		req.UserID = id
+	}
+
+	report, err := h.events.Replay(req.From, req.To, req.UserID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": err.Error(), "applied": report.Applied, "skipped": report.Skipped, "dropped": report.Dropped})
+	}
+	return c.JSON(fiber.Map{"applied": report.Applied, "skipped": report.Skipped, "dropped": report.Dropped, "from": req.From, "to": req.To, "user_id": req.UserID})
+}
+
+func (h *AdminHandler) GetDeadLetters(c *fiber.Ctx) error {
+	letters, err := h.deadLetters.List(c.QueryInt("limit", 100))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch dead letters"})
+	}
+	return c.JSON(letters)
+}
+
+func (h *AdminHandler) GetDeadLetter(c *fiber.Ctx) error {
+	letter, err := h.deadLetters.Get(c.Params("id"))
+	if err != nil {
+		return deadLetterError(c, err)
+	}
+	return c.JSON(letter)
+}
+
+func (h *AdminHandler) RetryDeadLetter(c *fiber.Ctx) error {
+	var req dto.DeadLetterRetryRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
+		}
+	}
+
+	letter, err := h.events.RetryDeadLetter(c.Params("id"), &req)
+	if err != nil {
+		return deadLetterError(c, err)
+	}
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{"status": "requeued", "dead_letter": letter})
+}
+
+func (h *AdminHandler) DeleteDeadLetter(c *fiber.Ctx) error {
+	if err := h.deadLetters.Delete(c.Params("id")); err != nil {
+		return deadLetterError(c, err)
+	}
+	return c.JSON(fiber.Map{"message": "Dead letter deleted"})
+}
+
+func (h *AdminHandler) PurgeDeadLetters(c *fiber.Ctx) error {
+	purged, err := h.deadLetters.Purge()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to purge dead letters"})
+	}
+	return c.JSON(fiber.Map{"purged": purged})
+}
+
+func deadLetterError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrDeadLetterNotFound):
+		return c.Status(404).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidEvent):
+		return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, services.ErrQueueFull):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(500).JSON(fiber.Map{"error": err.Error()})
+}
+
 func (h *AdminHandler) GetUserState(c *fiber.Ctx) error {
 	userID := c.Params("user_id")
 	state := h.userService.GetUserState(userID)
 	if state == nil {
 		return c.Status(404).JSON(fiber.Map{"error": "User state not found"})
 	}
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
 	return c.JSON(state)
 }
 
+func (h *AdminHandler) GetUserEnforcements(c *fiber.Ctx) error {
+	enforcements, err := h.userService.GetEnforcements(c.Params("id"), c.QueryInt("limit"))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to fetch enforcements"})
+	}
+	return c.JSON(enforcements)
+}
+
+func (h *AdminHandler) GetUserPositions(c *fiber.Ctx) error {
+	userID := c.Params("id")
+	positions, exposure, ok := h.userService.GetPositions(userID)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "User state not found"})
+	}
+	return c.JSON(dto.UserPositionsResponse{
+		UserID:    userID,
+		Positions: positions,
+		Exposure:  exposure,
+	})
+}
+
 func (h *AdminHandler) UpdateUserState(c *fiber.Ctx) error {
 	userID := c.Params("id")
-	
+
 	var req dto.UpdateUserStateRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
 	}
 
 	state := h.userService.UpdateUserState(userID, &req)
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
 	return c.JSON(state)
 }
 
@@ -94,12 +405,29 @@ func (h *AdminHandler) GetConnections(c *fiber.Ctx) error {
 }
 
 func (h *AdminHandler) GetMetrics(c *fiber.Ctx) error {
+	suppressed, suppressedByRule := h.cooldowns.Suppressed()
+	shadowSuppressed, shadowSuppressedByRule := h.cooldowns.ShadowSuppressed()
+	duplicates, duplicatesBySource := h.events.Duplicates()
+	depths := h.events.QueueDepths()
+	buffered := 0
+	for _, depth := range depths {
+		buffered += depth
+	}
+
 	metrics := &dto.MetricsResponse{
-		ActiveDLLConnections: h.dllService.GetActiveConnectionCount(),
-		WebSocketClients:     h.wsService.GetClientCount(),
-		UserStates:          h.userService.GetUserCount(),
-		EventBufferSize:     0, // Will be set by the calling service
-		Timestamp:           time.Now().Unix(),
+		ActiveDLLConnections:   h.dllService.GetActiveConnectionCount(),
+		WebSocketClients:       h.wsService.GetClientCount(),
+		UserStates:             h.userService.GetUserCount(),
+		EventBufferSize:        buffered,
+		PartitionDepths:        depths,
+		Backpressure:           h.dllService.BackpressureStats(),
+		DuplicateEvents:        duplicates,
+		DuplicatesBySource:     duplicatesBySource,
+		SuppressedEnforcements: suppressed,
+		SuppressedByRule:       suppressedByRule,
+		ShadowSuppressed:       shadowSuppressed,
+		ShadowSuppressedByRule: shadowSuppressedByRule,
+		Timestamp:              time.Now().Unix(),
 	}
 
 	return c.JSON(metrics)
@@ -107,7 +435,7 @@ func (h *AdminHandler) GetMetrics(c *fiber.Ctx) error {
 
 func (h *AdminHandler) ManualEnforce(c *fiber.Ctx) error {
 	userID := c.Params("userid")
-	
+
 	var req dto.EnforceRequest
 	if err := c.BodyParser(&req); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid JSON"})
@@ -121,8 +449,9 @@ func (h *AdminHandler) ManualEnforce(c *fiber.Ctx) error {
 		Timestamp: time.Now().Unix(),
 	}
 
+	h.userService.RecordEnforcement(enforcement)
 	h.dllService.SendEnforcement(enforcement)
 	h.wsService.SendEnforcement(enforcement)
 
 	return c.JSON(fiber.Map{"status": "sent", "enforcement": enforcement})
-}
\ No newline at end of file
+}
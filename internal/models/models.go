@@ -1,29 +1,91 @@
 package models
 
 import (
+	"encoding/json"
 	"net"
 	"sync"
 	"time"
-	"encoding/json"
-
 )
 
 type MT5Event struct {
-	UserId    string    `json:"user_id"`
-	EventType string    `json:"event_type"`
-	Symbol    string    `json:"symbol"`
-	Volume    float64   `json:"volume"`
-	Price     float64   `json:"price"`
-	Timestamp int64     `json:"timestamp"`
-	Data      []byte    `json:"data,omitempty"`
+	EventID     string  `json:"event_id,omitempty"`
+	Sequence    uint64  `json:"sequence,omitempty"`
+	Ticket      int64   `json:"ticket,omitempty"`
+	UserId      string  `json:"user_id"`
+	EventType   string  `json:"event_type"`
+	Symbol      string  `json:"symbol"`
+	Volume      float64 `json:"volume"`
+	Price       float64 `json:"price"`
+	Timestamp   int64   `json:"timestamp"`
+	PositionID  int64   `json:"position_id,omitempty"`
+	Side        string  `json:"side,omitempty"`
+	OrderType   string  `json:"order_type,omitempty"`
+	StopLoss    float64 `json:"sl,omitempty"`
+	TakeProfit  float64 `json:"tp,omitempty"`
+	Magic       int64   `json:"magic,omitempty"`
+	Comment     string  `json:"comment,omitempty"`
+	Amount      float64 `json:"amount,omitempty"`
+	MarginLevel float64 `json:"margin_level,omitempty"`
 }
 
+const (
+	EventOrderOpen     = "ORDER_OPEN"
+	EventOrderClose    = "ORDER_CLOSE"
+	EventOrderModify   = "ORDER_MODIFY"
+	EventPartialClose  = "PARTIAL_CLOSE"
+	EventPendingPlace  = "PENDING_PLACE"
+	EventPendingCancel = "PENDING_CANCEL"
+	EventBalanceUpdate = "BALANCE_UPDATE"
+	EventEquityUpdate  = "EQUITY_UPDATE"
+	EventDeposit       = "DEPOSIT"
+	EventWithdrawal    = "WITHDRAWAL"
+	EventMarginCall    = "MARGIN_CALL"
+	EventStopOut       = "STOP_OUT"
+	EventLogin         = "LOGIN"
+	EventLogout        = "LOGOUT"
+	EventScheduleTick  = "SCHEDULE_TICK"
+)
+
+const (
+	SideBuy  = "buy"
+	SideSell = "sell"
+)
+
+const (
+	OrderBuyLimit      = "buy_limit"
+	OrderSellLimit     = "sell_limit"
+	OrderBuyStop       = "buy_stop"
+	OrderSellStop      = "sell_stop"
+	OrderBuyStopLimit  = "buy_stop_limit"
+	OrderSellStopLimit = "sell_stop_limit"
+)
+
 type EnforcementMessage struct {
-	UserId    string `json:"user_id"`
-	Action    string `json:"action"`
-	Reason    string `json:"reason"`
-	Severity  int32  `json:"severity"`
-	Timestamp int64  `json:"timestamp"`
+	UserId         string           `json:"user_id"`
+	Action         string           `json:"action"`
+	Reason         string           `json:"reason"`
+	Severity       int32            `json:"severity"`
+	Timestamp      int64            `json:"timestamp"`
+	EscalationStep int              `json:"escalation_step,omitempty"`
+	Explanation    *RuleExplanation `json:"explanation,omitempty"`
+}
+
+type MatchedCondition struct {
+	Condition string      `json:"condition"`
+	Subject   string      `json:"subject"`
+	Operator  string      `json:"operator,omitempty"`
+	Observed  interface{} `json:"observed"`
+	Threshold interface{} `json:"threshold,omitempty"`
+	Summary   string      `json:"summary"`
+}
+
+type RuleExplanation struct {
+	RuleID     string             `json:"rule_id"`
+	RuleName   string             `json:"rule_name"`
+	Expression string             `json:"expression"`
+	EventType  string             `json:"event_type"`
+	Symbol     string             `json:"symbol,omitempty"`
+	Matched    []MatchedCondition `json:"matched"`
 }
 
 func (e *MT5Event) Serialize() ([]byte, error) {
@@ -63,33 +125,171 @@ func NewEnforcementMessage(userID, action, reason string, severity int32) *Enfor
 	}
 }
 
+const (
+	ActionWarn           = "warn"
+	ActionBlockOrder     = "block_order"
+	ActionClosePositions = "close_positions"
+	ActionDisableTrading = "disable_trading"
+	ActionSlowDown       = "slow_down"
+	ActionResume         = "resume"
+	ActionGoodbye        = "goodbye"
+)
+
 type Action struct {
 	Type     string `json:"type" redis:"type"`
 	Severity int32  `json:"severity" redis:"severity"`
 }
 
+const (
+	RuleModeEnforce  = "enforce"
+	RuleModeShadow   = "shadow"
+	RuleModeDisabled = "disabled"
+)
+
+const (
+	TriggerEvent    = "event"
+	TriggerSchedule = "schedule"
+)
+
 type Rule struct {
-	ID         string            `json:"id" redis:"id"`
-	Name       string            `json:"name" redis:"name"`
-	Conditions map[string]string `json:"conditions" redis:"conditions"`
-	Actions    []Action          `json:"actions" redis:"actions"`
-	Enabled    bool              `json:"enabled" redis:"enabled"`
-	Priority   int               `json:"priority" redis:"priority"`
-	CreatedAt  int64             `json:"created_at" redis:"created_at"`
-	UpdatedAt  int64             `json:"updated_at" redis:"updated_at"`
+	ID                string                 `json:"id" redis:"id"`
+	Name              string                 `json:"name" redis:"name"`
+	Conditions        map[string]string      `json:"conditions" redis:"conditions"`
+	Expression        string                 `json:"expression" redis:"expression"`
+	Actions           []Action               `json:"actions" redis:"actions"`
+	Enabled           bool                   `json:"enabled" redis:"enabled"`
+	Mode              string                 `json:"mode" redis:"mode"`
+	Priority          int                    `json:"priority" redis:"priority"`
+	Final             bool                   `json:"final" redis:"final"`
+	SingleAction      bool                   `json:"single_action" redis:"single_action"`
+	CooldownSeconds   int64                  `json:"cooldown_seconds" redis:"cooldown_seconds"`
+	CooldownPerSymbol bool                   `json:"cooldown_per_symbol" redis:"cooldown_per_symbol"`
+	Escalation        []Action               `json:"escalation" redis:"escalation"`
+	ViolationDecay    int64                  `json:"violation_decay_seconds" redis:"violation_decay_seconds"`
+	Trigger           string                 `json:"trigger" redis:"trigger"`
+	TemplateID        string                 `json:"template_id,omitempty" redis:"template_id"`
+	TemplateParams    map[string]interface{} `json:"template_params,omitempty" redis:"template_params"`
+	CreatedAt         int64                  `json:"created_at" redis:"created_at"`
+	UpdatedAt         int64                  `json:"updated_at" redis:"updated_at"`
+}
+
+const (
+	ParamNumber = "number"
+	ParamString = "string"
+)
+
+type TemplateParam struct {
+	Name        string      `json:"name"`
+	Type        string      `json:"type"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+type RuleTemplate struct {
+	ID                string          `json:"id"`
+	Name              string          `json:"name"`
+	Description       string          `json:"description"`
+	Params            []TemplateParam `json:"params"`
+	Expression        string          `json:"expression"`
+	Actions           []Action        `json:"actions"`
+	Priority          int             `json:"priority"`
+	Final             bool            `json:"final"`
+	SingleAction      bool            `json:"single_action"`
+	CooldownSeconds   int64           `json:"cooldown_seconds"`
+	CooldownPerSymbol bool            `json:"cooldown_per_symbol"`
+	Escalation        []Action        `json:"escalation"`
+	ViolationDecay    int64           `json:"violation_decay_seconds"`
+	Trigger           string          `json:"trigger"`
+	CreatedAt         int64           `json:"created_at"`
+	UpdatedAt         int64           `json:"updated_at"`
 }
 
 type UserState struct {
-	UserID         string            `json:"user_id" redis:"user_id"`
-	Balance        float64           `json:"balance" redis:"balance"`
-	Equity         float64           `json:"equity" redis:"equity"`
-	OpenPositions  int               `json:"open_positions" redis:"open_positions"`
-	DayVolume      float64           `json:"day_volume" redis:"day_volume"`
-	LastActivity   int64             `json:"last_activity" redis:"last_activity"`
-	RiskLevel      string            `json:"risk_level" redis:"risk_level"`
-	ViolationCount int               `json:"violation_count" redis:"violation_count"`
-	CustomData     map[string]string `json:"custom_data" redis:"custom_data"`
-	Mu             sync.RWMutex      `json:"-" redis:"-"`
+	UserID          string                       `json:"user_id" redis:"user_id"`
+	Balance         float64                      `json:"balance" redis:"balance"`
+	Equity          float64                      `json:"equity" redis:"equity"`
+	OpenPositions   int                          `json:"open_positions" redis:"open_positions"`
+	DayVolume       float64                      `json:"day_volume" redis:"day_volume"`
+	LastActivity    int64                        `json:"last_activity" redis:"last_activity"`
+	RiskLevel       string                       `json:"risk_level" redis:"risk_level"`
+	ViolationCount  int                          `json:"violation_count" redis:"violation_count"`
+	CustomData      map[string]string            `json:"custom_data" redis:"custom_data"`
+	InitialBalance  float64                      `json:"initial_balance" redis:"initial_balance"`
+	DayStartBalance float64                      `json:"day_start_balance" redis:"day_start_balance"`
+	DayStartEquity  float64                      `json:"day_start_equity" redis:"day_start_equity"`
+	DayStartedAt    int64                        `json:"day_started_at" redis:"day_started_at"`
+	EquityHighWater float64                      `json:"equity_high_water" redis:"equity_high_water"`
+	PendingOrders   int                          `json:"pending_orders" redis:"pending_orders"`
+	MarginLevel     float64                      `json:"margin_level" redis:"margin_level"`
+	MarginCalls     int                          `json:"margin_calls" redis:"margin_calls"`
+	StopOuts        int                          `json:"stop_outs" redis:"stop_outs"`
+	LoggedIn        bool                         `json:"logged_in" redis:"logged_in"`
+	LastLoginAt     int64                        `json:"last_login_at" redis:"last_login_at"`
+	Positions       map[int64]*Position          `json:"positions" redis:"positions"`
+	RuleViolations  map[string]*ViolationCounter `json:"rule_violations" redis:"rule_violations"`
+	LastEventID     string                       `json:"last_event_id" redis:"last_event_id"`
+	Mu              sync.RWMutex                 `json:"-" redis:"-"`
+}
+
+type Position struct {
+	Ticket     int64   `json:"ticket"`
+	Symbol     string  `json:"symbol"`
+	Side       string  `json:"side"`
+	Volume     float64 `json:"volume"`
+	OpenPrice  float64 `json:"open_price"`
+	OpenTime   int64   `json:"open_time"`
+	StopLoss   float64 `json:"sl,omitempty"`
+	TakeProfit float64 `json:"tp,omitempty"`
+	Magic      int64   `json:"magic,omitempty"`
+	Comment    string  `json:"comment,omitempty"`
+}
+
+type SymbolExposure struct {
+	Symbol    string  `json:"symbol"`
+	Long      float64 `json:"long"`
+	Short     float64 `json:"short"`
+	Net       float64 `json:"net"`
+	Gross     float64 `json:"gross"`
+	Positions int     `json:"positions"`
+}
+
+type Exposure struct {
+	Long     float64           `json:"long"`
+	Short    float64           `json:"short"`
+	Net      float64           `json:"net"`
+	Gross    float64           `json:"gross"`
+	BySymbol []*SymbolExposure `json:"by_symbol"`
+}
+
+type ViolationCounter struct {
+	Count  int   `json:"count"`
+	LastAt int64 `json:"last_at"`
+	Step   int   `json:"step"`
+}
+
+const (
+	DeadLetterDecode  = "decode"
+	DeadLetterInvalid = "invalid"
+	DeadLetterProcess = "process"
+)
+
+type DeadLetter struct {
+	ID            string    `json:"id"`
+	Source        string    `json:"source"`
+	Stage         string    `json:"stage"`
+	Raw           []byte    `json:"raw"`
+	Event         *MT5Event `json:"event,omitempty"`
+	Error         string    `json:"error"`
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt int64     `json:"first_failed_at"`
+	LastFailedAt  int64     `json:"last_failed_at"`
+}
+
+type EventLogEntry struct {
+	ID     string    `json:"id"`
+	Source string    `json:"source"`
+	Origin string    `json:"origin,omitempty"`
+	Event  *MT5Event `json:"event"`
 }
 
 type DLLConnection struct {
@@ -101,3 +301,114 @@ type DLLConnection struct {
 	EnforceChan chan *EnforcementMessage
 	Mu          sync.RWMutex
 }
+
+func (r *Rule) IsScheduled() bool {
+	return r.Trigger == TriggerSchedule
+}
+
+func (r *Rule) EffectiveMode() string {
+	if r.Mode != "" {
+		return r.Mode
+	}
+	if r.Enabled {
+		return RuleModeEnforce
+	}
+	return RuleModeDisabled
+}
+
+type ShadowEnforcement struct {
+	RuleID      string             `json:"rule_id"`
+	RuleName    string             `json:"rule_name"`
+	EventType   string             `json:"event_type"`
+	Symbol      string             `json:"symbol"`
+	Enforcement EnforcementMessage `json:"enforcement"`
+	RecordedAt  int64              `json:"recorded_at"`
+}
+
+const (
+	BacktestPending = "pending"
+	BacktestRunning = "running"
+	BacktestDone    = "done"
+	BacktestFailed  = "failed"
+)
+
+type BacktestHit struct {
+	UserID    string   `json:"user_id"`
+	Timestamp int64    `json:"timestamp"`
+	EventType string   `json:"event_type"`
+	Symbol    string   `json:"symbol"`
+	Actions   []string `json:"actions"`
+}
+
+type BacktestUserSummary struct {
+	UserID   string         `json:"user_id"`
+	Hits     int            `json:"hits"`
+	FirstHit int64          `json:"first_hit"`
+	LastHit  int64          `json:"last_hit"`
+	Actions  map[string]int `json:"actions"`
+}
+
+type BacktestReport struct {
+	EventsScanned int                    `json:"events_scanned"`
+	TotalHits     int                    `json:"total_hits"`
+	Suppressed    int                    `json:"suppressed"`
+	Users         []*BacktestUserSummary `json:"users"`
+	Hits          []*BacktestHit         `json:"hits"`
+	Truncated     bool                   `json:"truncated"`
+}
+
+type BacktestJob struct {
+	ID          string          `json:"id"`
+	Status      string          `json:"status"`
+	Rule        *Rule           `json:"rule"`
+	From        int64           `json:"from"`
+	To          int64           `json:"to"`
+	UserIDs     []string        `json:"user_ids,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Report      *BacktestReport `json:"report,omitempty"`
+	CreatedAt   int64           `json:"created_at"`
+	CompletedAt int64           `json:"completed_at,omitempty"`
+}
+
+const (
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionDelete   = "delete"
+	RevisionRollback = "rollback"
+)
+
+type RuleRevision struct {
+	RuleID         string `json:"rule_id"`
+	Revision       int64  `json:"revision"`
+	Operation      string `json:"operation"`
+	Author         string `json:"author"`
+	Timestamp      int64  `json:"timestamp"`
+	SourceRevision int64  `json:"source_revision,omitempty"`
+	Rule           *Rule  `json:"rule"`
+}
+
+type RuleFieldDiff struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+type RuleBundle struct {
+	Version    int             `json:"version"`
+	ExportedAt int64           `json:"exported_at"`
+	Templates  []*RuleTemplate `json:"templates,omitempty"`
+	Rules      []*Rule         `json:"rules"`
+}
+
+type BundleUpdate struct {
+	ID      string           `json:"id"`
+	Changes []*RuleFieldDiff `json:"changes"`
+}
+
+type BundlePlan struct {
+	Templates []string        `json:"templates"`
+	Create    []string        `json:"create"`
+	Update    []*BundleUpdate `json:"update"`
+	Delete    []string        `json:"delete"`
+	Applied   bool            `json:"applied"`
+}
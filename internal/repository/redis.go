@@ -3,13 +3,18 @@ package repository
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/NOTMKW/DLLBEL/internal/models"
 
 	"github.com/go-redis/redis/v8"
 )
 
+var ErrNotFound = errors.New("not found")
+
 type RedisRepository struct {
 	client *redis.Client
 	ctx context.Context
@@ -39,6 +44,9 @@ func (r *RedisRepository) SaveRule(rule *models.Rule) error {
 func (r *RedisRepository) GetRule(id string) (*models.Rule, error) {
 	key := fmt.Sprintf("rule:%s", id)
 	data, err := r.client.Get(r.ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -51,8 +59,19 @@ func (r *RedisRepository) GetRule(id string) (*models.Rule, error) {
 	return &rule, nil
 }
 
+const ruleChangesChannel = "rules:changed"
+
+func (r *RedisRepository) scanKeys(pattern string) ([]string, error) {
+	keys := []string{}
+	iter := r.client.Scan(r.ctx, 0, pattern, 100).Iterator()
+	for iter.Next(r.ctx) {
+		keys = append(keys, iter.Val())
+	}
+	return keys, iter.Err()
+}
+
 func (r *RedisRepository) GetAllRules() ([]*models.Rule, error) {
-	keys, err := r.client.Keys(r.ctx, "rule:*").Result()
+	keys, err := r.scanKeys("rule:*")
 	if err != nil {
 		return nil, err
 	}
@@ -79,12 +98,186 @@ func (r *RedisRepository) DeleteRule(id string) error {
 	return r.client.Del(r.ctx, key).Err()
 }
 
+func (r *RedisRepository) SaveTemplate(template *models.RuleTemplate) error {
+	data, err := json.Marshal(template)
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("rule_template:%s", template.ID)
+	return r.client.Set(r.ctx, key, data, 0).Err()
+}
+
+func (r *RedisRepository) GetTemplate(id string) (*models.RuleTemplate, error) {
+	key := fmt.Sprintf("rule_template:%s", id)
+	data, err := r.client.Get(r.ctx, key).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var template models.RuleTemplate
+	if err := json.Unmarshal([]byte(data), &template); err != nil {
+		return nil, err
+	}
+	return &template, nil
+}
+
+func (r *RedisRepository) GetAllTemplates() ([]*models.RuleTemplate, error) {
+	keys, err := r.scanKeys("rule_template:*")
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make([]*models.RuleTemplate, 0, len(keys))
+	for _, key := range keys {
+		data, err := r.client.Get(r.ctx, key).Result()
+		if err != nil {
+			continue
+		}
+
+		var template models.RuleTemplate
+		if err := json.Unmarshal([]byte(data), &template); err != nil {
+			continue
+		}
+		templates = append(templates, &template)
+	}
+	return templates, nil
+}
+
+func (r *RedisRepository) DeleteTemplate(id string) error {
+	key := fmt.Sprintf("rule_template:%s", id)
+	return r.client.Del(r.ctx, key).Err()
+}
+
+func (r *RedisRepository) AppendRuleRevision(revision *models.RuleRevision) error {
+	number, err := r.client.Incr(r.ctx, fmt.Sprintf("rule_revision_seq:%s", revision.RuleID)).Result()
+	if err != nil {
+		return err
+	}
+	revision.Revision = number
+
+	data, err := json.Marshal(revision)
+	if err != nil {
+		return err
+	}
+	return r.client.RPush(r.ctx, fmt.Sprintf("rule_revisions:%s", revision.RuleID), data).Err()
+}
+
+func (r *RedisRepository) GetRuleRevisions(id string) ([]*models.RuleRevision, error) {
+	items, err := r.client.LRange(r.ctx, fmt.Sprintf("rule_revisions:%s", id), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*models.RuleRevision, 0, len(items))
+	for _, item := range items {
+		var revision models.RuleRevision
+		if err := json.Unmarshal([]byte(item), &revision); err != nil {
+			continue
+		}
+		revisions = append(revisions, &revision)
+	}
+	return revisions, nil
+}
+
+func (r *RedisRepository) AcquireCooldown(key string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, key, 1, ttl).Result()
+}
+
+func (r *RedisRepository) MarkEventSeen(id string, ttl time.Duration) (bool, error) {
+	return r.client.SetNX(r.ctx, fmt.Sprintf("event_seen:%s", id), 1, ttl).Result()
+}
+
+func (r *RedisRepository) ForgetEvent(id string) error {
+	return r.client.Del(r.ctx, fmt.Sprintf("event_seen:%s", id)).Err()
+}
+
+const deadLetterKey = "dead_letters"
+
+func (r *RedisRepository) SaveDeadLetter(letter *models.DeadLetter) error {
+	data, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return r.client.HSet(r.ctx, deadLetterKey, letter.ID, data).Err()
+}
+
+func (r *RedisRepository) GetDeadLetter(id string) (*models.DeadLetter, error) {
+	data, err := r.client.HGet(r.ctx, deadLetterKey, id).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var letter models.DeadLetter
+	if err := json.Unmarshal([]byte(data), &letter); err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+func (r *RedisRepository) GetDeadLetters() ([]*models.DeadLetter, error) {
+	items, err := r.client.HGetAll(r.ctx, deadLetterKey).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	letters := make([]*models.DeadLetter, 0, len(items))
+	for _, item := range items {
+		var letter models.DeadLetter
+		if err := json.Unmarshal([]byte(item), &letter); err != nil {
+			continue
+		}
+		letters = append(letters, &letter)
+	}
+	return letters, nil
+}
+
+func (r *RedisRepository) DeleteDeadLetter(id string) (bool, error) {
+	n, err := r.client.HDel(r.ctx, deadLetterKey, id).Result()
+	return n > 0, err
+}
+
+func (r *RedisRepository) PurgeDeadLetters() (int64, error) {
+	n, err := r.client.HLen(r.ctx, deadLetterKey).Result()
+	if err != nil {
+		return 0, err
+	}
+	return n, r.client.Del(r.ctx, deadLetterKey).Err()
+}
+
+func (r *RedisRepository) PublishRuleChange(id string) error {
+	return r.client.Publish(r.ctx, ruleChangesChannel, id).Err()
+}
+
+func (r *RedisRepository) SubscribeRuleChanges() *redis.PubSub {
+	return r.client.Subscribe(r.ctx, ruleChangesChannel)
+}
+
+const enforcementsChannel = "enforcements:relay"
+
+func (r *RedisRepository) PublishEnforcement(data []byte) error {
+	return r.client.Publish(r.ctx, enforcementsChannel, data).Err()
+}
+
+func (r *RedisRepository) SubscribeEnforcements() *redis.PubSub {
+	return r.client.Subscribe(r.ctx, enforcementsChannel)
+}
+
 func (r *RedisRepository) SaveUserState(state *models.UserState) error {
 	data, err := json.Marshal(state)
 	if err != nil {
 		return err
 	}
-	key := fmt.Sprintf("user_state:%s", state.UserID)
+	return r.SaveUserStateData(state.UserID, data)
+}
+
+func (r *RedisRepository) SaveUserStateData(userID string, data []byte) error {
+	key := fmt.Sprintf("user_state:%s", userID)
 	return r.client.Set(r.ctx, key, data, 0).Err()
 }
 
@@ -103,6 +296,218 @@ func (r *RedisRepository) GetUserState(userID string) (*models.UserState, error)
 	return &state, nil
 }
 
+func (r *RedisRepository) GetUserIDs() ([]string, error) {
+	keys, err := r.scanKeys("user_state:*")
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(keys))
+	for _, key := range keys {
+		ids = append(ids, strings.TrimPrefix(key, "user_state:"))
+	}
+	return ids, nil
+}
+
+const (
+	shadowKey      = "shadow_enforcements"
+	shadowCapacity = 10000
+)
+
+func (r *RedisRepository) SaveShadowEnforcement(record *models.ShadowEnforcement) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	pipe := r.client.TxPipeline()
+	pipe.LPush(r.ctx, shadowKey, data)
+	pipe.LTrim(r.ctx, shadowKey, 0, shadowCapacity-1)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisRepository) GetShadowEnforcements() ([]*models.ShadowEnforcement, error) {
+	items, err := r.client.LRange(r.ctx, shadowKey, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*models.ShadowEnforcement, 0, len(items))
+	for _, item := range items {
+		var record models.ShadowEnforcement
+		if err := json.Unmarshal([]byte(item), &record); err != nil {
+			continue
+		}
+		records = append(records, &record)
+	}
+	return records, nil
+}
+
+const enforcementCapacity = 1000
+
+func (r *RedisRepository) SaveEnforcement(enforcement *models.EnforcementMessage) error {
+	data, err := json.Marshal(enforcement)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("enforcements:%s", enforcement.UserId)
+	pipe := r.client.TxPipeline()
+	pipe.LPush(r.ctx, key, data)
+	pipe.LTrim(r.ctx, key, 0, enforcementCapacity-1)
+	_, err = pipe.Exec(r.ctx)
+	return err
+}
+
+func (r *RedisRepository) GetEnforcements(userID string, limit int64) ([]*models.EnforcementMessage, error) {
+	items, err := r.client.LRange(r.ctx, fmt.Sprintf("enforcements:%s", userID), 0, limit-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	enforcements := make([]*models.EnforcementMessage, 0, len(items))
+	for _, item := range items {
+		var enforcement models.EnforcementMessage
+		if err := json.Unmarshal([]byte(item), &enforcement); err != nil {
+			continue
+		}
+		enforcements = append(enforcements, &enforcement)
+	}
+	return enforcements, nil
+}
+
+const eventHistoryKey = "events:history"
+
+func (r *RedisRepository) AppendEventHistory(source, origin string, event *models.MT5Event, maxLen int64) (string, error) {
+	data, err := event.Serialize()
+	if err != nil {
+		return "", err
+	}
+	return r.client.XAdd(r.ctx, &redis.XAddArgs{
+		Stream: eventHistoryKey,
+		MaxLen: maxLen,
+		Approx: true,
+		Values: map[string]interface{}{"event": data, "source": source, "origin": origin},
+	}).Result()
+}
+
+// CreateEventGroup starts a new group at the beginning of the log, so a
+// group created after a restart or an instance count change still sees
+// every retained entry; dedup and each user's LastEventID skip what was
+// already applied.
+func (r *RedisRepository) CreateEventGroup(group string) error {
+	err := r.client.XGroupCreateMkStream(r.ctx, eventHistoryKey, group, "0").Err()
+	if err != nil && strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+func (r *RedisRepository) ReadEventGroup(group, consumer, start string, count int64, block time.Duration) ([]*models.EventLogEntry, error) {
+	streams, err := r.client.XReadGroup(r.ctx, &redis.XReadGroupArgs{
+		Group:    group,
+		Consumer: consumer,
+		Streams:  []string{eventHistoryKey, start},
+		Count:    count,
+		Block:    block,
+	}).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*models.EventLogEntry{}
+	for _, stream := range streams {
+		for _, msg := range stream.Messages {
+			entries = append(entries, eventLogEntry(msg.ID, msg.Values))
+		}
+	}
+	return entries, nil
+}
+
+// ClaimEventGroup runs XAUTOCLAIM by hand: go-redis v8 cannot parse the
+// three-element reply that Redis 7 sends.
+func (r *RedisRepository) ClaimEventGroup(group, consumer string, minIdle time.Duration, start string, count int64) ([]*models.EventLogEntry, string, error) {
+	reply, err := r.client.Do(r.ctx, "XAUTOCLAIM", eventHistoryKey, group, consumer, minIdle.Milliseconds(), start, "COUNT", count).Slice()
+	if err != nil {
+		return nil, "", err
+	}
+	if len(reply) < 2 {
+		return nil, "", fmt.Errorf("unexpected XAUTOCLAIM reply of length %d", len(reply))
+	}
+
+	next, _ := reply[0].(string)
+	messages, _ := reply[1].([]interface{})
+	entries := []*models.EventLogEntry{}
+	for _, message := range messages {
+		parts, ok := message.([]interface{})
+		if !ok || len(parts) != 2 {
+			continue
+		}
+		id, _ := parts[0].(string)
+		fields, _ := parts[1].([]interface{})
+		values := make(map[string]interface{}, len(fields)/2)
+		for i := 0; i+1 < len(fields); i += 2 {
+			if key, ok := fields[i].(string); ok {
+				values[key] = fields[i+1]
+			}
+		}
+		entries = append(entries, eventLogEntry(id, values))
+	}
+	return entries, next, nil
+}
+
+func eventLogEntry(id string, values map[string]interface{}) *models.EventLogEntry {
+	entry := &models.EventLogEntry{ID: id, Event: &models.MT5Event{}}
+	entry.Source, _ = values["source"].(string)
+	entry.Origin, _ = values["origin"].(string)
+	raw, _ := values["event"].(string)
+	if err := entry.Event.Deserialize([]byte(raw)); err != nil {
+		entry.Event = nil
+	}
+	return entry
+}
+
+func (r *RedisRepository) AckEvent(group, id string) error {
+	return r.client.XAck(r.ctx, eventHistoryKey, group, id).Err()
+}
+
+func (r *RedisRepository) ScanEventHistory(from, to int64, fn func(*models.EventLogEntry) error) error {
+	start := fmt.Sprintf("%d", from*1000)
+	end := fmt.Sprintf("%d", to*1000+999)
+
+	for {
+		messages, err := r.client.XRangeN(r.ctx, eventHistoryKey, start, end, 1000).Result()
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range messages {
+			entry := eventLogEntry(msg.ID, msg.Values)
+			if entry.Event == nil {
+				continue
+			}
+			if err := fn(entry); err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < 1000 {
+			return nil
+		}
+		start = nextStreamID(messages[len(messages)-1].ID)
+	}
+}
+
+func nextStreamID(id string) string {
+	var ms, seq uint64
+	fmt.Sscanf(id, "%d-%d", &ms, &seq)
+	return fmt.Sprintf("%d-%d", ms, seq+1)
+}
+
 func (r *RedisRepository) Close() error {
 	return r.client.Close()
 }
\ No newline at end of file
@@ -14,10 +14,34 @@ func SetupRoutes(app *fiber.App, wsHandler *handlers.WebSocketHandler, adminHand
 	admin := app.Group("/admin")
 	admin.Get("/rules", adminHandler.GetRules)
 	admin.Post("/rules", adminHandler.CreateRule)
+	admin.Get("/rules/export", adminHandler.ExportRules)
+	admin.Post("/rules/import", adminHandler.ImportRules)
 	admin.Put("/rules/:id", adminHandler.UpdateRule)
 	admin.Delete("/rules/:id", adminHandler.DeleteRule)
+	admin.Get("/rules/:id/revisions", adminHandler.GetRuleRevisions)
+	admin.Get("/rules/:id/revisions/diff", adminHandler.DiffRuleRevisions)
+	admin.Post("/rules/:id/rollback", adminHandler.RollbackRule)
+	admin.Get("/templates", adminHandler.GetTemplates)
+	admin.Post("/templates", adminHandler.CreateTemplate)
+	admin.Get("/templates/:id", adminHandler.GetTemplate)
+	admin.Put("/templates/:id", adminHandler.UpdateTemplate)
+	admin.Delete("/templates/:id", adminHandler.DeleteTemplate)
+	admin.Post("/templates/:id/rules", adminHandler.InstantiateTemplate)
+	admin.Get("/shadow", adminHandler.GetShadowEnforcements)
+	admin.Get("/backtests", adminHandler.GetBacktests)
+	admin.Post("/backtests", adminHandler.CreateBacktest)
+	admin.Get("/backtests/:id", adminHandler.GetBacktest)
+	admin.Post("/events/replay", adminHandler.ReplayEvents)
+	admin.Get("/dead-letters", adminHandler.GetDeadLetters)
+	admin.Delete("/dead-letters", adminHandler.PurgeDeadLetters)
+	admin.Get("/dead-letters/:id", adminHandler.GetDeadLetter)
+	admin.Post("/dead-letters/:id/retry", adminHandler.RetryDeadLetter)
+	admin.Delete("/dead-letters/:id", adminHandler.DeleteDeadLetter)
+	admin.Post("/users/:id/events/replay", adminHandler.ReplayEvents)
 	admin.Get("/users/:id/state", adminHandler.GetUserState)
 	admin.Put("/users/:id/state", adminHandler.UpdateUserState)
+	admin.Get("/users/:id/enforcements", adminHandler.GetUserEnforcements)
+	admin.Get("/users/:id/positions", adminHandler.GetUserPositions)
 	admin.Get("/connections", adminHandler.GetConnections)
 	admin.Get("/metrics", adminHandler.GetMetrics)
 	admin.Post("/enforce/:userid", adminHandler.ManualEnforce)
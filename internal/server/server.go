@@ -3,6 +3,8 @@ package server
 import (
 	"context"
 	"log"
+	"os"
+	"path/filepath"
 
 	"github.com/NOTMKW/DLLBEL/internal/config"
 	"github.com/NOTMKW/DLLBEL/internal/handlers"
@@ -17,7 +19,11 @@ import (
 type Server struct {
 	app          *fiber.App
 	config       *config.Config
+	ruleService  *services.RuleService
 	eventService *services.EventService
+	userService  *services.UserService
+	dllService   *services.DLLService
+	wsService    *services.WebSocketService
 	repo         *repository.RedisRepository
 }
 
@@ -39,26 +45,71 @@ func NewServer(cfg *config.Config) *Server {
 
 	repo := repository.NewRedisRepository(cfg.RedisAddr, cfg.RedisPass, cfg.RedisDB)
 
-	ruleService := services.NewRuleService(repo)
-	userService := services.NewUserService(repo)
-	wsService := services.NewWebSocketService()
-	dllService := services.NewDLLService(nil)
+	windowService := services.NewWindowService(cfg.WindowMaxEvents, cfg.WindowRetention)
+	clock, err := services.NewBrokerClock(cfg.BrokerTimezone, cfg.BrokerRollover)
+	if err != nil {
+		log.Fatalf("invalid broker clock configuration: %v", err)
+	}
+	if err := services.ValidateBackpressurePolicy(cfg.Backpressure); err != nil {
+		log.Fatalf("invalid event backpressure configuration: %v", err)
+	}
+	if err := services.ValidateInstance(cfg.Instance, cfg.Instances); err != nil {
+		log.Fatalf("invalid engine instance configuration: %v", err)
+	}
+	if cfg.RuleRefresh <= 0 {
+		log.Fatalf("invalid rule refresh configuration: RULE_REFRESH_SECONDS must be positive, got %v", cfg.RuleRefresh)
+	}
+	if cfg.ScheduleEvery <= 0 {
+		log.Fatalf("invalid rule schedule configuration: RULE_SCHEDULE_SECONDS must be positive, got %v", cfg.ScheduleEvery)
+	}
 
-	eventService := services.NewEventService(ruleService, userService, dllService, wsService, cfg.EventBuffer)
-	dllService.SetEventChannel(eventService.GetEventChannel())
+	ruleService := services.NewRuleService(repo, windowService, clock)
+	userService := services.NewUserService(repo, clock)
+	wsService := services.NewWebSocketService()
+	deadLetterService := services.NewDeadLetterService(repo)
+	dllService := services.NewDLLService(nil, repo, cfg.Backpressure, cfg.BackpressureMax, deadLetterService)
+	shadowService := services.NewShadowService(repo, wsService)
+	historyService := services.NewHistoryService(repo, cfg.HistoryMaxLen, cfg.Instance, cfg.Instances)
+	cooldownService := services.NewCooldownService(repo)
+	dedupService := services.NewDedupService(repo, cfg.DedupRetention)
+	templateService := services.NewTemplateService(repo, ruleService)
+	backtestService := services.NewBacktestService(ruleService, userService, historyService, windowService, cfg.ScheduleEvery)
+
+	eventService := services.NewEventService(ruleService, userService, windowService, dllService, wsService, shadowService, historyService, cooldownService, dedupService, deadLetterService, cfg.EventBuffer, cfg.Workers)
+	dllService.SetEventSink(eventService)
 
 	wsHandler := handlers.NewWebSocketHandler(wsService)
-	adminHandler := handlers.NewAdminHandler(ruleService, wsService, dllService, userService)
+	adminHandler := handlers.NewAdminHandler(ruleService, wsService, dllService, userService, shadowService, backtestService, cooldownService, templateService, eventService, deadLetterService)
 	dllHandler := handlers.NewDLLHandler(dllService)
 
 	routes.SetupRoutes(app, wsHandler, adminHandler, dllHandler)
 
-	eventService.Start(cfg.Workers)
+	if cfg.RuleBundlePath != "" {
+		if err := loadRuleBundle(ruleService, cfg.RuleBundlePath); err != nil {
+			log.Fatalf("failed to load rule bundle %s: %v", cfg.RuleBundlePath, err)
+		}
+	}
+	if err := ruleService.Reload(); err != nil {
+		log.Printf("Failed to load rules: %v", err)
+	}
+	ruleService.StartSync(cfg.RuleRefresh)
+	if loaded, err := userService.LoadStates(historyService.Owns); err != nil {
+		log.Printf("Failed to load user states: %v", err)
+	} else {
+		log.Printf("Loaded state for %d users", loaded)
+	}
+	dllService.StartRelay()
+	eventService.Start()
+	eventService.StartScheduler(cfg.ScheduleEvery)
 
 	return &Server{
 		app:          app,
 		config:       cfg,
+		ruleService:  ruleService,
 		eventService: eventService,
+		userService:  userService,
+		dllService:   dllService,
+		wsService:    wsService,
 		repo:         repo,
 	}
 }
@@ -68,10 +119,66 @@ func (s *Server) Start() error {
 }
 
 func (s *Server) Shutdown() error {
-	log.Println("Shutting down services...")
+	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+
+	log.Printf("Shutting down services (deadline %s)...", s.config.ShutdownTimeout)
+
+	s.dllService.StopAccepting()
+	httpDone := make(chan error, 1)
+	go func() {
+		httpDone <- s.app.ShutdownWithContext(ctx)
+	}()
+	log.Println("Stopped accepting DLL and WebSocket connections")
+
+	drain := s.eventService.Drain(ctx)
+	log.Printf("Drained %d events from the queue", drain.Drained)
+
+	saved, failed := s.userService.Flush(ctx)
+	log.Printf("Flushed %d user states to Redis, %d failed", saved, failed)
 
-	s.eventService.Stop()
+	notified, missed := s.dllService.Goodbye(ctx)
+	clients := s.wsService.CloseAll("engine is shutting down")
+	log.Printf("Sent goodbye to %d DLL connections (%d missed) and %d WebSocket clients", notified, missed, clients)
+
+	s.ruleService.StopSync()
+
+	var err error
+	select {
+	case err = <-httpDone:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
 	s.repo.Close()
 
-	return s.app.ShutdownWithContext(context.Background())
+	if drain.TimedOut || ctx.Err() != nil {
+		log.Printf("Shutdown deadline of %s exceeded", s.config.ShutdownTimeout)
+	}
+	log.Printf("Shutdown complete: %d events drained, %d left in the event log for the next start, %d lost, %d user states saved, %d user states lost",
+		drain.Drained, drain.Retained, drain.Lost, saved, failed)
+	return err
+}
+
+func loadRuleBundle(ruleService *services.RuleService, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	format := "json"
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+
+	bundle, err := services.DecodeBundle(data, format)
+	if err != nil {
+		return err
+	}
+
+	plan, err := ruleService.ImportBundle(bundle, false, "bundle:"+path)
+	if err != nil {
+		return err
+	}
+	log.Printf("Loaded rule bundle %s: %d created, %d updated, %d deleted", path, len(plan.Create), len(plan.Update), len(plan.Delete))
+	return nil
 }
@@ -0,0 +1,166 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+const (
+	BackpressureBlock      = "block"
+	BackpressureDropOldest = "drop_oldest"
+	BackpressureDropNewest = "drop_newest"
+	BackpressureSlowDown   = "slow_down"
+)
+
+func ValidateBackpressurePolicy(policy string) error {
+	switch policy {
+	case BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureSlowDown:
+		return nil
+	}
+	return fmt.Errorf("invalid backpressure policy %q, expected %s, %s, %s or %s", policy, BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest, BackpressureSlowDown)
+}
+
+type flowStats struct {
+	mu             sync.Mutex
+	dropped        map[string]map[string]int64
+	delayed        map[string]map[string]int64
+	slowDownFrames map[string]int64
+	throttled      map[string]bool
+}
+
+func newFlowStats() *flowStats {
+	return &flowStats{
+		dropped:        make(map[string]map[string]int64),
+		delayed:        make(map[string]map[string]int64),
+		slowDownFrames: make(map[string]int64),
+		throttled:      make(map[string]bool),
+	}
+}
+
+func (f *flowStats) count(counters map[string]map[string]int64, dllID, eventType string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	byType, ok := counters[dllID]
+	if !ok {
+		byType = make(map[string]int64)
+		counters[dllID] = byType
+	}
+	byType[eventType]++
+}
+
+func (f *flowStats) snapshot(policy string) *dto.BackpressureStats {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	stats := &dto.BackpressureStats{
+		Policy:         policy,
+		Dropped:        copyCounters(f.dropped),
+		Delayed:        copyCounters(f.delayed),
+		SlowDownFrames: make(map[string]int64, len(f.slowDownFrames)),
+	}
+	for dllID, n := range f.slowDownFrames {
+		stats.SlowDownFrames[dllID] = n
+	}
+	for _, byType := range stats.Dropped {
+		for _, n := range byType {
+			stats.TotalDropped += n
+		}
+	}
+	for _, byType := range stats.Delayed {
+		for _, n := range byType {
+			stats.TotalDelayed += n
+		}
+	}
+	return stats
+}
+
+func copyCounters(counters map[string]map[string]int64) map[string]map[string]int64 {
+	out := make(map[string]map[string]int64, len(counters))
+	for dllID, byType := range counters {
+		out[dllID] = make(map[string]int64, len(byType))
+		for eventType, n := range byType {
+			out[dllID][eventType] = n
+		}
+	}
+	return out
+}
+
+func (s *DLLService) submit(dllConn *models.DLLConnection, event *models.MT5Event) {
+	switch s.policy {
+	case BackpressureDropNewest:
+		if !s.events.TrySubmit(dllConn.ID, event) {
+			s.dropped(dllConn.ID, event, "queue full")
+		}
+	case BackpressureDropOldest:
+		evicted, accepted := s.events.SubmitEvict(dllConn.ID, event, s.timeout)
+		for _, old := range evicted {
+			s.dropped(old.source, old.event, "evicted by newer event")
+		}
+		if !accepted {
+			s.dropped(dllConn.ID, event, fmt.Sprintf("nothing to evict and queue still full after %s", s.timeout))
+		}
+	case BackpressureBlock:
+		if s.events.TrySubmit(dllConn.ID, event) {
+			return
+		}
+		s.wait(dllConn, event)
+	case BackpressureSlowDown:
+		if s.events.TrySubmit(dllConn.ID, event) {
+			s.setThrottled(dllConn, false)
+			return
+		}
+		s.setThrottled(dllConn, true)
+		s.wait(dllConn, event)
+	}
+}
+
+func (s *DLLService) wait(dllConn *models.DLLConnection, event *models.MT5Event) {
+	s.stats.count(s.stats.delayed, dllConn.ID, event.EventType)
+	if !s.events.SubmitWait(dllConn.ID, event, s.timeout) {
+		s.dropped(dllConn.ID, event, fmt.Sprintf("queue still full after %s", s.timeout))
+	}
+}
+
+func (s *DLLService) dropped(dllID string, event *models.MT5Event, reason string) {
+	s.stats.count(s.stats.dropped, dllID, event.EventType)
+	log.Printf("Dropped %s event for user %s from DLL %s: %s", event.EventType, event.UserId, dllID, reason)
+}
+
+func (s *DLLService) setThrottled(dllConn *models.DLLConnection, throttled bool) {
+	s.stats.mu.Lock()
+	changed := s.stats.throttled[dllConn.ID] != throttled
+	s.stats.throttled[dllConn.ID] = throttled
+	if changed && throttled {
+		s.stats.slowDownFrames[dllConn.ID]++
+	}
+	s.stats.mu.Unlock()
+	if !changed {
+		return
+	}
+
+	frame := &models.EnforcementMessage{
+		Action:    models.ActionResume,
+		Reason:    "engine event queue has capacity again",
+		Timestamp: time.Now().Unix(),
+	}
+	if throttled {
+		frame.Action = models.ActionSlowDown
+		frame.Reason = "engine event queue is full"
+	}
+
+	select {
+	case dllConn.EnforceChan <- frame:
+	default:
+		log.Printf("Enforcement channel full, could not send %s to DLL %s", frame.Action, dllConn.ID)
+	}
+}
+
+func (s *DLLService) BackpressureStats() *dto.BackpressureStats {
+	return s.stats.snapshot(s.policy)
+}
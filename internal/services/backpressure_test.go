@@ -0,0 +1,147 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+const testBackpressureTimeout = 50 * time.Millisecond
+
+// newPolicyEngine returns an engine whose two inflight slots are held by
+// events that are in the event log but not yet dispatched.
+func newPolicyEngine(t *testing.T, policy string) (*testEngine, *DLLService, *models.DLLConnection, string) {
+	t.Helper()
+
+	e := newTestEngine(t, 0, 1, 2, 1)
+	if err := e.history.Join(); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	dll := NewDLLService(e.events, nil, policy, testBackpressureTimeout, e.deadLetters)
+	conn := &models.DLLConnection{ID: "dll-1", EnforceChan: make(chan *models.EnforcementMessage, 4)}
+	user := userFor(e.history, true)
+
+	for i := 1; i <= 2; i++ {
+		dll.submit(conn, testOpen(user, uint64(i)))
+	}
+	if len(e.events.inflight) != 2 {
+		t.Fatalf("inflight = %d, want 2", len(e.events.inflight))
+	}
+	return e, dll, conn, user
+}
+
+func testOpen(user string, seq uint64) *models.MT5Event {
+	return &models.MT5Event{UserId: user, EventType: models.EventOrderOpen, Sequence: seq, Symbol: "EURUSD", Volume: 1}
+}
+
+func dropped(dll *DLLService) int64 {
+	return dll.BackpressureStats().Dropped["dll-1"][models.EventOrderOpen]
+}
+
+func TestDropNewestDropsWhenFull(t *testing.T) {
+	_, dll, conn, user := newPolicyEngine(t, BackpressureDropNewest)
+
+	dll.submit(conn, testOpen(user, 3))
+	if n := dropped(dll); n != 1 {
+		t.Errorf("dropped = %d, want 1", n)
+	}
+}
+
+func TestBlockWaitsThenDrops(t *testing.T) {
+	_, dll, conn, user := newPolicyEngine(t, BackpressureBlock)
+
+	start := time.Now()
+	dll.submit(conn, testOpen(user, 3))
+	if elapsed := time.Since(start); elapsed < testBackpressureTimeout {
+		t.Errorf("submit returned after %s, want it to wait %s", elapsed, testBackpressureTimeout)
+	}
+	stats := dll.BackpressureStats()
+	if stats.TotalDelayed != 1 || stats.TotalDropped != 1 {
+		t.Errorf("delayed = %d, dropped = %d, want 1 and 1", stats.TotalDelayed, stats.TotalDropped)
+	}
+}
+
+func TestBlockAcceptsWhenSlotFrees(t *testing.T) {
+	e, dll, conn, user := newPolicyEngine(t, BackpressureBlock)
+
+	go func() {
+		time.Sleep(testBackpressureTimeout / 5)
+		e.events.release()
+	}()
+	dll.submit(conn, testOpen(user, 3))
+	if stats := dll.BackpressureStats(); stats.TotalDelayed != 1 || stats.TotalDropped != 0 {
+		t.Errorf("delayed = %d, dropped = %d, want 1 and 0", stats.TotalDelayed, stats.TotalDropped)
+	}
+}
+
+func TestSlowDownThrottlesAndResumes(t *testing.T) {
+	e, dll, conn, user := newPolicyEngine(t, BackpressureSlowDown)
+
+	dll.submit(conn, testOpen(user, 3))
+	if frame := <-conn.EnforceChan; frame.Action != models.ActionSlowDown {
+		t.Fatalf("first frame = %s, want %s", frame.Action, models.ActionSlowDown)
+	}
+
+	e.events.release()
+	dll.submit(conn, testOpen(user, 4))
+	if frame := <-conn.EnforceChan; frame.Action != models.ActionResume {
+		t.Fatalf("second frame = %s, want %s", frame.Action, models.ActionResume)
+	}
+	if stats := dll.BackpressureStats(); stats.SlowDownFrames["dll-1"] != 1 {
+		t.Errorf("slow-down frames = %d, want 1", stats.SlowDownFrames["dll-1"])
+	}
+}
+
+func TestDropOldestReturnsWhenNothingToEvict(t *testing.T) {
+	_, dll, conn, user := newPolicyEngine(t, BackpressureDropOldest)
+
+	done := make(chan struct{})
+	go func() {
+		dll.submit(conn, testOpen(user, 3))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("drop_oldest blocked with every slot held by undispatched events")
+	}
+	if n := dropped(dll); n != 1 {
+		t.Errorf("dropped = %d, want the new event", n)
+	}
+}
+
+func TestDropOldestEvictsOnlyOwnSlots(t *testing.T) {
+	e, dll, conn, user := newPolicyEngine(t, BackpressureDropOldest)
+
+	// An event appended by another instance holds no slot here.
+	foreign := queuedEvent{id: "1-0", source: "dll-2", event: testOpen(user, 99)}
+	if !e.events.enqueue(foreign) {
+		t.Fatal("enqueue failed")
+	}
+	entries, err := e.history.Next(10, time.Millisecond)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Next = %d entries, %v, want 2", len(entries), err)
+	}
+	// Only the first reaches the partition; the second still holds its slot
+	// on the way through the event log.
+	e.events.dispatch(entries[0])
+
+	dll.submit(conn, testOpen(user, 3))
+	if n := dropped(dll); n != 1 {
+		t.Fatalf("dropped = %d, want one evicted event", n)
+	}
+	if pending := e.fake.Pending(e.history.group); pending != 1 {
+		t.Errorf("%d entries still pending, want only the undispatched one", pending)
+	}
+
+	var processed []uint64
+	for len(e.events.partitions[0]) > 0 {
+		if item := <-e.events.partitions[0]; item.start() {
+			processed = append(processed, item.event.Sequence)
+		}
+	}
+	if len(processed) != 1 || processed[0] != 99 {
+		t.Errorf("workers would process sequences %v, want only the foreign event", processed)
+	}
+}
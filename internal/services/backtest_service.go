@@ -0,0 +1,309 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+const (
+	maxBacktestHits = 1000
+	maxBacktestJobs = 100
+)
+
+type BacktestService struct {
+	ruleService   *RuleService
+	userService   *UserService
+	history       *HistoryService
+	windows       *WindowService
+	scheduleEvery time.Duration
+	jobs          map[string]*models.BacktestJob
+	order         []string
+	mu            sync.RWMutex
+}
+
+func NewBacktestService(ruleService *RuleService, userService *UserService, history *HistoryService, windows *WindowService, scheduleEvery time.Duration) *BacktestService {
+	return &BacktestService{
+		ruleService:   ruleService,
+		userService:   userService,
+		history:       history,
+		windows:       windows,
+		scheduleEvery: scheduleEvery,
+		jobs:          make(map[string]*models.BacktestJob),
+	}
+}
+
+func (s *BacktestService) Submit(req *dto.BacktestRequest) (*models.BacktestJob, error) {
+	if req.From <= 0 || req.To < req.From {
+		verr := &ValidationError{}
+		verr.add("to", "time range %d-%d is invalid", req.From, req.To)
+		return nil, verr
+	}
+
+	rule, err := s.ruleService.BuildRule(&req.Rule)
+	if err != nil {
+		return nil, err
+	}
+	expr, err := compileRule(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &models.BacktestJob{
+		ID:        fmt.Sprintf("backtest-%d", time.Now().UnixNano()),
+		Status:    models.BacktestPending,
+		Rule:      rule,
+		From:      req.From,
+		To:        req.To,
+		UserIDs:   req.UserIDs,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.order = append(s.order, job.ID)
+	if len(s.order) > maxBacktestJobs {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	snapshot := *job
+	s.mu.Unlock()
+
+	go s.run(job.ID, &CompiledRule{Rule: rule, Expr: expr}, req)
+
+	return &snapshot, nil
+}
+
+func (s *BacktestService) GetJob(id string) *models.BacktestJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return nil
+	}
+	snapshot := *job
+	return &snapshot
+}
+
+func (s *BacktestService) GetJobs() []*models.BacktestJob {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	jobs := make([]*models.BacktestJob, 0, len(s.order))
+	for i := len(s.order) - 1; i >= 0; i-- {
+		snapshot := *s.jobs[s.order[i]]
+		snapshot.Report = nil
+		jobs = append(jobs, &snapshot)
+	}
+	return jobs
+}
+
+func (s *BacktestService) run(id string, rule *CompiledRule, req *dto.BacktestRequest) {
+	s.setStatus(id, models.BacktestRunning, nil, "")
+
+	report, err := s.replay(rule, req)
+	if err != nil {
+		s.setStatus(id, models.BacktestFailed, nil, err.Error())
+		return
+	}
+	s.setStatus(id, models.BacktestDone, report, "")
+}
+
+func (s *BacktestService) setStatus(id, status string, report *models.BacktestReport, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, exists := s.jobs[id]
+	if !exists {
+		return
+	}
+	job.Status = status
+	job.Report = report
+	job.Error = errMsg
+	if status == models.BacktestDone || status == models.BacktestFailed {
+		job.CompletedAt = time.Now().Unix()
+	}
+}
+
+// backtestRun replays history against one rule the way applyRules would
+// have run it: behind the live final rules ahead of it, with its cooldown,
+// escalation ladder and trigger.
+type backtestRun struct {
+	service   *BacktestService
+	rule      *CompiledRule
+	blockers  []*CompiledRule
+	states    map[string]*models.UserState
+	users     []string
+	windows   *WindowService
+	cooldowns map[string]int64
+	summaries map[string]*models.BacktestUserSummary
+	report    *models.BacktestReport
+	nextTick  int64
+}
+
+func (s *BacktestService) replay(rule *CompiledRule, req *dto.BacktestRequest) (*models.BacktestReport, error) {
+	users := make(map[string]bool, len(req.UserIDs))
+	for _, id := range req.UserIDs {
+		users[id] = true
+	}
+
+	run := &backtestRun{
+		service:   s,
+		rule:      rule,
+		blockers:  s.finalRulesBefore(rule),
+		states:    make(map[string]*models.UserState),
+		windows:   s.windows.Empty(),
+		cooldowns: make(map[string]int64),
+		summaries: make(map[string]*models.BacktestUserSummary),
+		report:    &models.BacktestReport{Hits: []*models.BacktestHit{}},
+		nextTick:  req.From + int64(s.scheduleEvery/time.Second),
+	}
+	report := run.report
+
+	err := s.history.Scan(req.From, req.To, func(entry *models.EventLogEntry) error {
+		event := entry.Event
+		if len(users) > 0 && !users[event.UserId] {
+			return nil
+		}
+		report.EventsScanned++
+		run.tick(event.Timestamp)
+
+		state, exists := run.states[event.UserId]
+		if !exists {
+			state = &models.UserState{UserID: event.UserId, CustomData: make(map[string]string)}
+			run.states[event.UserId] = state
+			run.users = append(run.users, event.UserId)
+		}
+		s.userService.ApplyEvent(state, event, event.Timestamp)
+		run.windows.Record(event)
+
+		if !rule.IsScheduled() {
+			run.evaluate(event, state)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	run.tick(req.To)
+
+	report.Users = make([]*models.BacktestUserSummary, 0, len(run.summaries))
+	for _, summary := range run.summaries {
+		report.Users = append(report.Users, summary)
+	}
+	sort.Slice(report.Users, func(i, j int) bool {
+		if report.Users[i].Hits != report.Users[j].Hits {
+			return report.Users[i].Hits > report.Users[j].Hits
+		}
+		return report.Users[i].UserID < report.Users[j].UserID
+	})
+
+	return report, nil
+}
+
+// finalRulesBefore returns the live final rules with the same trigger that
+// applyRules would reach, and stop at, before the backtested rule.
+func (s *BacktestService) finalRulesBefore(rule *CompiledRule) []*CompiledRule {
+	compiled := make(map[*models.Rule]*CompiledRule)
+	ordered := []*models.Rule{rule.Rule}
+	for _, live := range s.ruleService.Rules() {
+		if live.ID == rule.ID || live.IsScheduled() != rule.IsScheduled() {
+			continue
+		}
+		compiled[live.Rule] = live
+		ordered = append(ordered, live.Rule)
+	}
+	SortRules(ordered)
+
+	blockers := []*CompiledRule{}
+	for _, candidate := range ordered {
+		if candidate == rule.Rule {
+			break
+		}
+		if candidate.Final && candidate.EffectiveMode() == models.RuleModeEnforce {
+			blockers = append(blockers, compiled[candidate])
+		}
+	}
+	return blockers
+}
+
+// tick runs a scheduled rule for every user seen so far at each scheduler
+// interval up to now.
+func (r *backtestRun) tick(now int64) {
+	interval := int64(r.service.scheduleEvery / time.Second)
+	if !r.rule.IsScheduled() || interval <= 0 {
+		return
+	}
+
+	for ; r.nextTick <= now; r.nextTick += interval {
+		for _, user := range r.users {
+			tick := &models.MT5Event{
+				UserId:    user,
+				EventType: models.EventScheduleTick,
+				Timestamp: r.nextTick,
+			}
+			r.evaluate(tick, r.states[user])
+		}
+	}
+}
+
+func (r *backtestRun) evaluate(event *models.MT5Event, state *models.UserState) {
+	rules := r.service.ruleService
+	for _, blocker := range r.blockers {
+		if rules.evaluateWith(blocker, event, state, r.windows) {
+			return
+		}
+	}
+	if !rules.evaluateWith(r.rule, event, state, r.windows) {
+		return
+	}
+
+	now := event.Timestamp
+	if r.rule.CooldownSeconds > 0 {
+		scope := cooldownScope(r.rule.Rule, event)
+		if last, ok := r.cooldowns[scope]; ok && now-last < r.rule.CooldownSeconds {
+			r.report.Suppressed++
+			return
+		}
+		r.cooldowns[scope] = now
+	}
+
+	violation := recordViolation(state, r.rule.Rule, now)
+	actions := []string{}
+	for _, action := range rules.ActionsForStep(r.rule.Rule, violation.Step) {
+		actions = append(actions, action.Type)
+	}
+
+	summary, exists := r.summaries[event.UserId]
+	if !exists {
+		summary = &models.BacktestUserSummary{
+			UserID:   event.UserId,
+			FirstHit: now,
+			Actions:  make(map[string]int),
+		}
+		r.summaries[event.UserId] = summary
+	}
+	summary.Hits++
+	summary.LastHit = now
+	for _, action := range actions {
+		summary.Actions[action]++
+	}
+
+	r.report.TotalHits++
+	if len(r.report.Hits) < maxBacktestHits {
+		r.report.Hits = append(r.report.Hits, &models.BacktestHit{
+			UserID:    event.UserId,
+			Timestamp: now,
+			EventType: event.EventType,
+			Symbol:    event.Symbol,
+			Actions:   actions,
+		})
+	} else {
+		r.report.Truncated = true
+	}
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+type backtestFixture struct {
+	engine   *testEngine
+	backtest *BacktestService
+	from     int64
+}
+
+func newBacktestFixture(t *testing.T) *backtestFixture {
+	t.Helper()
+
+	e := newTestEngine(t, 0, 1, 8, 1)
+	return &backtestFixture{
+		engine:   e,
+		backtest: NewBacktestService(e.rules, e.users, e.history, e.windows, 10*time.Second),
+		from:     time.Now().Unix() - 60,
+	}
+}
+
+// append logs an event that happened offset seconds after the start of the
+// backtest window.
+func (f *backtestFixture) append(t *testing.T, offset int64, event *models.MT5Event) {
+	t.Helper()
+	event.Timestamp = f.from + offset
+	if _, err := f.engine.history.Append("dll-1", event); err != nil {
+		t.Fatalf("Append: %v", err)
+	}
+}
+
+func (f *backtestFixture) run(t *testing.T, req dto.CreateRuleRequest) *models.BacktestReport {
+	t.Helper()
+
+	rule, err := f.engine.rules.BuildRule(&req)
+	if err != nil {
+		t.Fatalf("BuildRule: %v", err)
+	}
+	expr, err := compileRule(rule)
+	if err != nil {
+		t.Fatalf("compileRule: %v", err)
+	}
+	report, err := f.backtest.replay(&CompiledRule{Rule: rule, Expr: expr}, &dto.BacktestRequest{From: f.from, To: f.from + 120})
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return report
+}
+
+func hitActions(report *models.BacktestReport) []string {
+	actions := []string{}
+	for _, hit := range report.Hits {
+		actions = append(actions, hit.Actions...)
+	}
+	return actions
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func trade(volume float64) *models.MT5Event {
+	return &models.MT5Event{UserId: "alice", EventType: models.EventOrderOpen, Symbol: "EURUSD", Volume: volume}
+}
+
+var ladder = []models.Action{
+	{Type: models.ActionWarn, Severity: 1},
+	{Type: models.ActionBlockOrder, Severity: 2},
+	{Type: models.ActionDisableTrading, Severity: 3},
+}
+
+func TestBacktestWalksEscalationLadder(t *testing.T) {
+	f := newBacktestFixture(t)
+	for i := int64(0); i < 4; i++ {
+		f.append(t, i, trade(5))
+	}
+
+	report := f.run(t, dto.CreateRuleRequest{
+		Name:       "big trades",
+		Expression: "event.volume > 1",
+		Enabled:    true,
+		Escalation: ladder,
+	})
+
+	want := []string{models.ActionWarn, models.ActionBlockOrder, models.ActionDisableTrading, models.ActionDisableTrading}
+	if got := hitActions(report); !equalStrings(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestBacktestDecaysViolations(t *testing.T) {
+	f := newBacktestFixture(t)
+	f.append(t, 0, trade(5))
+	f.append(t, 10, trade(5))
+	// Two decay periods later both earlier violations have expired.
+	f.append(t, 80, trade(5))
+
+	report := f.run(t, dto.CreateRuleRequest{
+		Name:           "big trades",
+		Expression:     "event.volume > 1",
+		Enabled:        true,
+		Escalation:     ladder,
+		ViolationDecay: 30,
+	})
+
+	want := []string{models.ActionWarn, models.ActionBlockOrder, models.ActionWarn}
+	if got := hitActions(report); !equalStrings(got, want) {
+		t.Errorf("actions = %v, want %v", got, want)
+	}
+}
+
+func TestBacktestAppliesCooldown(t *testing.T) {
+	f := newBacktestFixture(t)
+	f.append(t, 0, trade(5))
+	f.append(t, 10, trade(5))
+	f.append(t, 40, trade(5))
+
+	report := f.run(t, dto.CreateRuleRequest{
+		Name:            "big trades",
+		Expression:      "event.volume > 1",
+		Enabled:         true,
+		Actions:         []models.Action{{Type: models.ActionWarn, Severity: 1}},
+		CooldownSeconds: 30,
+	})
+
+	if report.TotalHits != 2 || report.Suppressed != 1 {
+		t.Errorf("hits = %d, suppressed = %d, want 2 and 1", report.TotalHits, report.Suppressed)
+	}
+}
+
+func TestBacktestStopsBehindFinalRules(t *testing.T) {
+	f := newBacktestFixture(t)
+	_, err := f.engine.rules.CreateRule(&dto.CreateRuleRequest{
+		Name:       "gold handled first",
+		Expression: "event.symbol == 'XAUUSD'",
+		Enabled:    true,
+		Priority:   10,
+		Final:      true,
+		Actions:    []models.Action{{Type: models.ActionBlockOrder, Severity: 2}},
+	}, "test")
+	if err != nil {
+		t.Fatalf("CreateRule: %v", err)
+	}
+
+	f.append(t, 0, trade(5))
+	gold := trade(5)
+	gold.Symbol = "XAUUSD"
+	f.append(t, 1, gold)
+	f.append(t, 2, trade(5))
+
+	report := f.run(t, dto.CreateRuleRequest{
+		Name:       "big trades",
+		Expression: "event.volume > 1",
+		Enabled:    true,
+		Actions:    []models.Action{{Type: models.ActionWarn, Severity: 1}},
+	})
+
+	if report.TotalHits != 2 {
+		t.Errorf("hits = %d, want 2: the gold trade never reaches the rule", report.TotalHits)
+	}
+}
+
+func TestBacktestRunsScheduledRulesOnTicks(t *testing.T) {
+	f := newBacktestFixture(t)
+	open := trade(1)
+	open.Ticket = 7
+	f.append(t, 5, open)
+	f.append(t, 35, &models.MT5Event{UserId: "alice", EventType: models.EventOrderClose, Ticket: 7})
+
+	report := f.run(t, dto.CreateRuleRequest{
+		Name:       "holding positions",
+		Expression: "state.open_positions > 0",
+		Enabled:    true,
+		Trigger:    models.TriggerSchedule,
+		Actions:    []models.Action{{Type: models.ActionWarn, Severity: 1}},
+	})
+
+	if report.TotalHits != 3 {
+		t.Fatalf("hits = %d, want one per tick while the position was open", report.TotalHits)
+	}
+	for i, hit := range report.Hits {
+		if hit.EventType != models.EventScheduleTick || hit.Timestamp != f.from+int64(10*(i+1)) {
+			t.Errorf("hit %d = %s at %d", i, hit.EventType, hit.Timestamp-f.from)
+		}
+	}
+}
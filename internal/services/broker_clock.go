@@ -0,0 +1,57 @@
+package services
+
+import (
+	"fmt"
+	"time"
+)
+
+type BrokerClock struct {
+	loc      *time.Location
+	rollover time.Duration
+}
+
+func NewBrokerClock(timezone, rollover string) (*BrokerClock, error) {
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker timezone %q: %w", timezone, err)
+	}
+
+	at, err := time.Parse("15:04", rollover)
+	if err != nil {
+		return nil, fmt.Errorf("invalid broker day rollover %q, expected HH:MM", rollover)
+	}
+
+	return &BrokerClock{
+		loc:      loc,
+		rollover: time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute,
+	}, nil
+}
+
+func (c *BrokerClock) Location() *time.Location {
+	return c.loc
+}
+
+func (c *BrokerClock) DayStart(unix int64) int64 {
+	t := time.Unix(unix, 0).In(c.loc)
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, c.loc).Add(c.rollover)
+	if start.After(t) {
+		start = time.Date(t.Year(), t.Month(), t.Day()-1, 0, 0, 0, 0, c.loc).Add(c.rollover)
+	}
+	return start.Unix()
+}
+
+func (c *BrokerClock) NextDayStart(unix int64) int64 {
+	return time.Unix(c.DayStart(unix), 0).In(c.loc).AddDate(0, 0, 1).Unix()
+}
+
+func (c *BrokerClock) Time(unix int64) time.Time {
+	return time.Unix(unix, 0).In(c.loc)
+}
+
+func parseClock(value string) (int, error) {
+	at, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", value)
+	}
+	return at.Hour()*60 + at.Minute(), nil
+}
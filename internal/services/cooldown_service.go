@@ -0,0 +1,88 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+type CooldownService struct {
+	repo     *repository.RedisRepository
+	enforced *suppressionCounter
+	shadowed *suppressionCounter
+}
+
+type suppressionCounter struct {
+	mu     sync.Mutex
+	byRule map[string]int64
+	total  int64
+}
+
+func NewCooldownService(repo *repository.RedisRepository) *CooldownService {
+	return &CooldownService{
+		repo:     repo,
+		enforced: &suppressionCounter{byRule: make(map[string]int64)},
+		shadowed: &suppressionCounter{byRule: make(map[string]int64)},
+	}
+}
+
+// Allow reports whether the rule may fire for the event. Shadow firings use
+// their own keys and counters so they never hold back a live enforcement.
+func (s *CooldownService) Allow(rule *models.Rule, mode string, event *models.MT5Event) bool {
+	if rule.CooldownSeconds <= 0 {
+		return true
+	}
+
+	prefix, counter := "cooldown", s.enforced
+	if mode == models.RuleModeShadow {
+		prefix, counter = "cooldown:shadow", s.shadowed
+	}
+	key := prefix + ":" + cooldownScope(rule, event)
+	acquired, err := s.repo.AcquireCooldown(key, time.Duration(rule.CooldownSeconds)*time.Second)
+	if err != nil {
+		log.Printf("Cooldown check failed for rule %s, allowing enforcement: %v", rule.ID, err)
+		return true
+	}
+	if acquired {
+		return true
+	}
+
+	counter.mu.Lock()
+	counter.byRule[rule.ID]++
+	counter.total++
+	counter.mu.Unlock()
+	return false
+}
+
+// cooldownScope identifies what one cooldown covers: the rule for a user,
+// or for a user and symbol.
+func cooldownScope(rule *models.Rule, event *models.MT5Event) string {
+	scope := fmt.Sprintf("%s:%s", rule.ID, event.UserId)
+	if rule.CooldownPerSymbol {
+		scope += ":" + event.Symbol
+	}
+	return scope
+}
+
+func (s *CooldownService) Suppressed() (int64, map[string]int64) {
+	return s.enforced.snapshot()
+}
+
+func (s *CooldownService) ShadowSuppressed() (int64, map[string]int64) {
+	return s.shadowed.snapshot()
+}
+
+func (c *suppressionCounter) snapshot() (int64, map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	byRule := make(map[string]int64, len(c.byRule))
+	for id, count := range c.byRule {
+		byRule[id] = count
+	}
+	return c.total, byRule
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestCooldownKeepsShadowAndLiveApart(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	s := NewCooldownService(repo)
+	rule := &models.Rule{ID: "big-trades", CooldownSeconds: 60}
+	event := &models.MT5Event{UserId: "alice", Symbol: "EURUSD"}
+
+	if !s.Allow(rule, models.RuleModeShadow, event) {
+		t.Fatal("first shadow firing suppressed")
+	}
+	// The shadow firing must not hold back the live one.
+	if !s.Allow(rule, models.RuleModeEnforce, event) {
+		t.Fatal("live firing suppressed by an earlier shadow firing")
+	}
+	if s.Allow(rule, models.RuleModeEnforce, event) {
+		t.Error("second live firing within the cooldown allowed")
+	}
+	if s.Allow(rule, models.RuleModeShadow, event) {
+		t.Error("second shadow firing within the cooldown allowed")
+	}
+	s.Allow(rule, models.RuleModeShadow, event)
+
+	if total, byRule := s.Suppressed(); total != 1 || byRule[rule.ID] != 1 {
+		t.Errorf("Suppressed = %d, %v, want 1 live suppression", total, byRule)
+	}
+	if total, byRule := s.ShadowSuppressed(); total != 2 || byRule[rule.ID] != 2 {
+		t.Errorf("ShadowSuppressed = %d, %v, want 2 shadow suppressions", total, byRule)
+	}
+}
+
+func TestCooldownScopes(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	s := NewCooldownService(repo)
+	perUser := &models.Rule{ID: "per-user", CooldownSeconds: 60}
+	perSymbol := &models.Rule{ID: "per-symbol", CooldownSeconds: 60, CooldownPerSymbol: true}
+	uncapped := &models.Rule{ID: "uncapped"}
+
+	alice := &models.MT5Event{UserId: "alice", Symbol: "EURUSD"}
+	aliceGold := &models.MT5Event{UserId: "alice", Symbol: "XAUUSD"}
+	bob := &models.MT5Event{UserId: "bob", Symbol: "EURUSD"}
+
+	cases := []struct {
+		name  string
+		rule  *models.Rule
+		event *models.MT5Event
+		want  bool
+	}{
+		{"first firing", perUser, alice, true},
+		{"same user, other symbol", perUser, aliceGold, false},
+		{"other user", perUser, bob, true},
+		{"per symbol, first firing", perSymbol, alice, true},
+		{"per symbol, other symbol", perSymbol, aliceGold, true},
+		{"per symbol, same symbol", perSymbol, alice, false},
+		{"no cooldown", uncapped, alice, true},
+		{"no cooldown, again", uncapped, alice, true},
+	}
+	for _, c := range cases {
+		if got := s.Allow(c.rule, models.RuleModeEnforce, c.event); got != c.want {
+			t.Errorf("%s: Allow = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+var (
+	ErrDeadLetterNotFound = errors.New("dead letter not found")
+	ErrInvalidEvent       = errors.New("invalid event")
+	ErrQueueFull          = errors.New("event queue is full")
+)
+
+type DeadLetterService struct {
+	repo *repository.RedisRepository
+}
+
+func NewDeadLetterService(repo *repository.RedisRepository) *DeadLetterService {
+	return &DeadLetterService{repo: repo}
+}
+
+func (s *DeadLetterService) Record(source, stage string, raw []byte, event *models.MT5Event, cause error) {
+	now := time.Now().Unix()
+	letter := &models.DeadLetter{
+		ID:            fmt.Sprintf("dlq-%d", time.Now().UnixNano()),
+		Source:        source,
+		Stage:         stage,
+		Raw:           raw,
+		Event:         event,
+		Error:         cause.Error(),
+		Attempts:      1,
+		FirstFailedAt: now,
+		LastFailedAt:  now,
+	}
+
+	if err := s.repo.SaveDeadLetter(letter); err != nil {
+		log.Printf("Failed to dead-letter %s event from %s (%v): %v", stage, source, cause, err)
+		return
+	}
+	log.Printf("Dead-lettered event %s from %s at %s stage: %v", letter.ID, source, stage, cause)
+}
+
+func (s *DeadLetterService) Fail(letter *models.DeadLetter, cause error) {
+	letter.Attempts++
+	letter.Error = cause.Error()
+	letter.LastFailedAt = time.Now().Unix()
+
+	if err := s.repo.SaveDeadLetter(letter); err != nil {
+		log.Printf("Failed to update dead letter %s: %v", letter.ID, err)
+	}
+}
+
+func (s *DeadLetterService) Resolve(letter *models.DeadLetter) {
+	if _, err := s.repo.DeleteDeadLetter(letter.ID); err != nil {
+		log.Printf("Failed to remove resolved dead letter %s: %v", letter.ID, err)
+	}
+}
+
+func (s *DeadLetterService) List(limit int) ([]*models.DeadLetter, error) {
+	letters, err := s.repo.GetDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(letters, func(i, j int) bool { return letters[i].LastFailedAt > letters[j].LastFailedAt })
+	if limit > 0 && len(letters) > limit {
+		letters = letters[:limit]
+	}
+	return letters, nil
+}
+
+func (s *DeadLetterService) Get(id string) (*models.DeadLetter, error) {
+	letter, err := s.repo.GetDeadLetter(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	return letter, err
+}
+
+func (s *DeadLetterService) Delete(id string) error {
+	deleted, err := s.repo.DeleteDeadLetter(id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return fmt.Errorf("%w: %s", ErrDeadLetterNotFound, id)
+	}
+	return nil
+}
+
+func (s *DeadLetterService) Purge() (int64, error) {
+	return s.repo.PurgeDeadLetters()
+}
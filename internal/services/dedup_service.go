@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+type DedupService struct {
+	repo       *repository.RedisRepository
+	retention  time.Duration
+	duplicates map[string]int64
+	total      int64
+	mu         sync.Mutex
+}
+
+func NewDedupService(repo *repository.RedisRepository, retention time.Duration) *DedupService {
+	return &DedupService{
+		repo:       repo,
+		retention:  retention,
+		duplicates: make(map[string]int64),
+	}
+}
+
+// Only events that happen once per ticket can derive their ID from it;
+// a position can be modified or partially closed many times. Tickets are
+// unique only within one trade server, so the ID is scoped to the user.
+var ticketScopedEvents = map[string]bool{
+	models.EventOrderOpen:     true,
+	models.EventOrderClose:    true,
+	models.EventPendingPlace:  true,
+	models.EventPendingCancel: true,
+}
+
+func AssignEventID(source string, event *models.MT5Event) {
+	if event.EventID != "" {
+		return
+	}
+	switch {
+	case event.Ticket != 0 && ticketScopedEvents[event.EventType]:
+		event.EventID = fmt.Sprintf("ticket:%s:%d:%s", event.UserId, event.Ticket, event.EventType)
+	case event.Sequence != 0:
+		event.EventID = fmt.Sprintf("%s:%d", source, event.Sequence)
+	}
+}
+
+func (s *DedupService) FirstSeen(source string, event *models.MT5Event) bool {
+	if event.EventID == "" || s.retention <= 0 {
+		return true
+	}
+
+	first, err := s.repo.MarkEventSeen(event.EventID, s.retention)
+	if err != nil {
+		log.Printf("Duplicate check failed for event %s, accepting it: %v", event.EventID, err)
+		return true
+	}
+	if first {
+		return true
+	}
+
+	s.mu.Lock()
+	s.duplicates[source]++
+	s.total++
+	s.mu.Unlock()
+	log.Printf("Suppressed duplicate %s event %s for user %s from %s", event.EventType, event.EventID, event.UserId, source)
+	return false
+}
+
+// Forget clears the event's seen marker so that a resend is accepted, for
+// events that were dropped before they became durable.
+func (s *DedupService) Forget(event *models.MT5Event) {
+	if event.EventID == "" || s.retention <= 0 {
+		return
+	}
+	if err := s.repo.ForgetEvent(event.EventID); err != nil {
+		log.Printf("Failed to clear duplicate marker for event %s: %v", event.EventID, err)
+	}
+}
+
+func (s *DedupService) Duplicates() (int64, map[string]int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bySource := make(map[string]int64, len(s.duplicates))
+	for source, count := range s.duplicates {
+		bySource[source] = count
+	}
+	return s.total, bySource
+}
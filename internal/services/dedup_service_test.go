@@ -0,0 +1,58 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestAssignEventID(t *testing.T) {
+	tests := []struct {
+		name  string
+		event models.MT5Event
+		want  string
+	}{
+		{"explicit id", models.MT5Event{EventID: "abc", UserId: "u1", Ticket: 7, EventType: models.EventOrderOpen}, "abc"},
+		{"open by ticket", models.MT5Event{UserId: "u1", Ticket: 7, Sequence: 3, EventType: models.EventOrderOpen}, "ticket:u1:7:ORDER_OPEN"},
+		{"close by ticket", models.MT5Event{UserId: "u1", Ticket: 7, EventType: models.EventOrderClose}, "ticket:u1:7:ORDER_CLOSE"},
+		{"modify by sequence", models.MT5Event{UserId: "u1", Ticket: 7, Sequence: 3, EventType: models.EventOrderModify}, "dll-1:3"},
+		{"partial close by sequence", models.MT5Event{UserId: "u1", Ticket: 7, Sequence: 4, EventType: models.EventPartialClose}, "dll-1:4"},
+		{"modify without sequence", models.MT5Event{UserId: "u1", Ticket: 7, EventType: models.EventOrderModify}, ""},
+		{"no ticket or sequence", models.MT5Event{UserId: "u1", EventType: models.EventOrderOpen}, ""},
+	}
+
+	for _, tt := range tests {
+		event := tt.event
+		AssignEventID("dll-1", &event)
+		if event.EventID != tt.want {
+			t.Errorf("%s: EventID = %q, want %q", tt.name, event.EventID, tt.want)
+		}
+	}
+}
+
+func TestFirstSeenScopesTicketsToUser(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	dedup := NewDedupService(repo, time.Hour)
+
+	accept := func(source, user string) bool {
+		event := &models.MT5Event{UserId: user, Ticket: 1001, EventType: models.EventOrderOpen, Symbol: "EURUSD", Volume: 1}
+		AssignEventID(source, event)
+		return dedup.FirstSeen(source, event)
+	}
+
+	if !accept("dll-a", "alice") {
+		t.Fatal("first open for alice was suppressed")
+	}
+	if !accept("dll-b", "bob") {
+		t.Fatal("bob's open with the same ticket on another server was suppressed as a duplicate")
+	}
+	if accept("dll-a", "alice") {
+		t.Fatal("alice's resent open was accepted twice")
+	}
+
+	total, bySource := dedup.Duplicates()
+	if total != 1 || bySource["dll-a"] != 1 {
+		t.Errorf("Duplicates() = %d, %v, want 1 from dll-a", total, bySource)
+	}
+}
@@ -3,6 +3,7 @@ package services
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
 	"log"
 	"net"
 	"sync"
@@ -10,30 +11,64 @@ import (
 
 	"github.com/NOTMKW/DLLBEL/internal/dto"
 	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
 )
 
+const maxFrameSize = 1 << 20
+
+type EventSink interface {
+	TrySubmit(source string, event *models.MT5Event) bool
+	SubmitWait(source string, event *models.MT5Event, timeout time.Duration) bool
+	SubmitEvict(source string, event *models.MT5Event, timeout time.Duration) ([]queuedEvent, bool)
+}
+
 type DLLService struct {
 	connections map[string]*models.DLLConnection
 	mu          sync.RWMutex
-	eventChan   chan *models.MT5Event
+	events      EventSink
+	policy      string
+	timeout     time.Duration
+	stats       *flowStats
+	deadLetters *DeadLetterService
+	listeners   []net.Listener
+	closing     bool
+	writers     map[*models.DLLConnection]chan struct{}
+	repo        *repository.RedisRepository
+	routes      map[string]string
+	relayDone   chan struct{}
 }
 
-func NewDLLService(eventChan chan *models.MT5Event) *DLLService {
+func NewDLLService(events EventSink, repo *repository.RedisRepository, policy string, timeout time.Duration, deadLetters *DeadLetterService) *DLLService {
 	return &DLLService{
 		connections: make(map[string]*models.DLLConnection),
-		eventChan:   eventChan,
+		events:      events,
+		policy:      policy,
+		timeout:     timeout,
+		stats:       newFlowStats(),
+		deadLetters: deadLetters,
+		writers:     make(map[*models.DLLConnection]chan struct{}),
+		repo:        repo,
+		routes:      make(map[string]string),
+		relayDone:   make(chan struct{}),
 	}
 }
-
-func (s *DLLService) SetEventChannel(eventChan chan *models.MT5Event) {
-	s.eventChan = eventChan
-}
+
+func (s *DLLService) SetEventSink(events EventSink) {
+	s.events = events
+}
 
 func (s *DLLService) StartListener(dllID string) error {
+	if s.isClosing() {
+		return errShuttingDown
+	}
+
 	listener, err := net.Listen("tcp", ":0")
 	if err != nil {
 		return err
 	}
+	s.mu.Lock()
+	s.listeners = append(s.listeners, listener)
+	s.mu.Unlock()
 
 	port := listener.Addr().(*net.TCPAddr).Port
 	log.Printf("DLL %s listening on port %d", dllID, port)
@@ -44,6 +79,9 @@ func (s *DLLService) StartListener(dllID string) error {
 		for {
 			conn, err := listener.Accept()
 			if err != nil {
+				if s.isClosing() {
+					return
+				}
 				continue
 			}
 
@@ -56,12 +94,14 @@ func (s *DLLService) StartListener(dllID string) error {
 				EnforceChan: make(chan *models.EnforcementMessage, 1000),
 			}
 
+			written := make(chan struct{})
 			s.mu.Lock()
 			s.connections[dllID] = dllConn
+			s.writers[dllConn] = written
 			s.mu.Unlock()
 
 			go s.handleConnection(dllConn)
-			go s.enforceWriter(dllConn)
+			go s.enforceWriter(dllConn, written)
 		}
 	}()
 
@@ -70,6 +110,9 @@ func (s *DLLService) StartListener(dllID string) error {
 
 func (s *DLLService) handleConnection(dllConn *models.DLLConnection) {
 	defer func() {
+		if s.isClosing() {
+			return
+		}
 		dllConn.Conn.Close()
 		s.mu.Lock()
 		delete(s.connections, dllConn.ID)
@@ -77,21 +120,33 @@ func (s *DLLService) handleConnection(dllConn *models.DLLConnection) {
 	}()
 
 	buffer := make([]byte, 8192)
+	var pending []byte
+	defer func() {
+		if len(pending) > 0 {
+			s.deadLetters.Record(dllConn.ID, models.DeadLetterDecode, pending, nil, fmt.Errorf("connection closed with %d bytes of an incomplete frame", len(pending)))
+		}
+	}()
 	
 	for {
 		n, err := dllConn.Conn.Read(buffer)
+		if err != nil && s.isClosing() {
+			break
+		}
 		if err != nil {
 			log.Printf("DLL connection %s read error: %v", dllConn.ID, err)
 			break
 		}
 
-		events := s.parseBinaryProtocol(buffer[:n])
+		pending = append(pending, buffer[:n]...)
+		events, consumed, err := s.parseBinaryProtocol(dllConn.ID, pending)
+		pending = append(pending[:0], pending[consumed:]...)
 		for _, event := range events {
-			select {
-			case s.eventChan <- event:
-			default:
-				log.Printf("Event buffer full, dropping event from DLL %s", dllConn.ID)
-			}
+			s.route(event.UserId, dllConn.ID)
+			s.submit(dllConn, event)
+		}
+		if err != nil {
+			log.Printf("DLL connection %s protocol error, closing: %v", dllConn.ID, err)
+			break
 		}
 
 		dllConn.Mu.Lock()
@@ -100,57 +155,128 @@ func (s *DLLService) handleConnection(dllConn *models.DLLConnection) {
 	}
 }
 
-func (s *DLLService) parseBinaryProtocol(data []byte) []*models.MT5Event {
+// parseBinaryProtocol decodes every complete frame in data and reports how
+// many bytes it consumed; an incomplete trailing frame is left for the next
+// read. A frame length over maxFrameSize cannot be resynchronised and is an
+// error.
+func (s *DLLService) parseBinaryProtocol(dllID string, data []byte) ([]*models.MT5Event, int, error) {
 	events := []*models.MT5Event{}
-	reader := bytes.NewReader(data)
-
-	for reader.Len() > 0 {
-		var msgLen uint32
-		if err := binary.Read(reader, binary.LittleEndian, &msgLen); err != nil {
-			break
+	consumed := 0
+
+	for len(data)-consumed >= 4 {
+		msgLen := binary.LittleEndian.Uint32(data[consumed:])
+		if msgLen > maxFrameSize {
+			err := fmt.Errorf("frame length %d exceeds %d bytes", msgLen, maxFrameSize)
+			s.deadLetters.Record(dllID, models.DeadLetterDecode, append([]byte(nil), data[consumed:]...), nil, err)
+			return events, len(data), err
 		}
-
-		if msgLen > uint32(reader.Len()) {
+		if uint64(len(data)-consumed-4) < uint64(msgLen) {
 			break
 		}
 
-		msgData := make([]byte, msgLen)
-		if _, err := reader.Read(msgData); err != nil {
-			break
-		}
+		start := consumed + 4
+		consumed = start + int(msgLen)
+		msgData := append([]byte(nil), data[start:consumed]...)
 
 		event := &models.MT5Event{}
 		if err := event.Deserialize(msgData); err != nil {
-			log.Printf("Failed to deserialize MT5Event: %v", err)
+			s.deadLetters.Record(dllID, models.DeadLetterDecode, msgData, nil, err)
+			continue
+		}
+		if err := ValidateEvent(event); err != nil {
+			s.deadLetters.Record(dllID, models.DeadLetterInvalid, msgData, event, err)
 			continue
 		}
 		events = append(events, event)
 	}
 
-	return events
+	return events, consumed, nil
 }
 
-func (s *DLLService) SendEnforcement(enforcement *models.EnforcementMessage) {
+// route remembers the DLL a user's events arrive on, so enforcements for
+// the user go back to the same terminal.
+func (s *DLLService) route(userID, dllID string) {
 	s.mu.RLock()
-	var targetConn *models.DLLConnection
-	for _, conn := range s.connections {
-		if conn.IsActive {
-			targetConn = conn
-			break
-		}
+	current := s.routes[userID]
+	s.mu.RUnlock()
+	if current == dllID {
+		return
 	}
+
+	s.mu.Lock()
+	s.routes[userID] = dllID
+	s.mu.Unlock()
+}
+
+// SendEnforcement delivers to the DLL the user trades through. When that
+// connection is held by another instance, the enforcement is relayed to it.
+func (s *DLLService) SendEnforcement(enforcement *models.EnforcementMessage) {
+	if s.deliver(enforcement) {
+		return
+	}
+
+	data, err := enforcement.Serialize()
+	if err != nil {
+		log.Printf("Failed to encode enforcement for user %s: %v", enforcement.UserId, err)
+		return
+	}
+	if err := s.repo.PublishEnforcement(data); err != nil {
+		log.Printf("Failed to relay enforcement for user %s: %v", enforcement.UserId, err)
+	}
+}
+
+func (s *DLLService) deliver(enforcement *models.EnforcementMessage) bool {
+	s.mu.RLock()
+	targetConn, ok := s.connections[s.routes[enforcement.UserId]]
 	s.mu.RUnlock()
+	if !ok || !targetConn.IsActive {
+		return false
+	}
 
-	if targetConn != nil {
-		select {
-		case targetConn.EnforceChan <- enforcement:
-		default:
-			log.Printf("Enforcement channel full for DLL %s", targetConn.ID)
-		}
+	select {
+	case targetConn.EnforceChan <- enforcement:
+	default:
+		log.Printf("Enforcement channel full for DLL %s", targetConn.ID)
 	}
+	return true
 }
 
-func (s *DLLService) enforceWriter(dllConn *models.DLLConnection) {
+// StartRelay delivers enforcements relayed by other instances to the users
+// whose DLL connections this instance holds, until Goodbye.
+func (s *DLLService) StartRelay() {
+	pubsub := s.repo.SubscribeEnforcements()
+
+	go func() {
+		defer pubsub.Close()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				enforcement := &models.EnforcementMessage{}
+				if err := enforcement.Deserialize([]byte(msg.Payload)); err != nil {
+					log.Printf("Skipping unreadable relayed enforcement: %v", err)
+					continue
+				}
+				s.deliver(enforcement)
+			case <-s.relayDone:
+				return
+			}
+		}
+	}()
+}
+
+func (s *DLLService) enforceWriter(dllConn *models.DLLConnection, written chan struct{}) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.writers, dllConn)
+		s.mu.Unlock()
+		close(written)
+	}()
+
 	for enforcement := range dllConn.EnforceChan {
 		data, err := enforcement.Serialize()
 		if err != nil {
@@ -162,6 +288,9 @@ func (s *DLLService) enforceWriter(dllConn *models.DLLConnection) {
 		buf.Write(data)
 
 		dllConn.Conn.Write(buf.Bytes())
+		if enforcement.Action == models.ActionGoodbye {
+			return
+		}
 	}
 }
 
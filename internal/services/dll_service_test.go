@@ -0,0 +1,112 @@
+package services
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+type acceptAll struct {
+	events chan *models.MT5Event
+}
+
+func (a *acceptAll) TrySubmit(source string, event *models.MT5Event) bool {
+	a.events <- event
+	return true
+}
+
+func (a *acceptAll) SubmitWait(source string, event *models.MT5Event, timeout time.Duration) bool {
+	return a.TrySubmit(source, event)
+}
+
+func (a *acceptAll) SubmitEvict(source string, event *models.MT5Event, timeout time.Duration) ([]queuedEvent, bool) {
+	return nil, a.TrySubmit(source, event)
+}
+
+// connect attaches a piped DLL connection to the service and returns the
+// terminal's end of it.
+func connect(t *testing.T, s *DLLService, dllID string) (net.Conn, *models.DLLConnection) {
+	t.Helper()
+
+	server, terminal := net.Pipe()
+	dllConn := &models.DLLConnection{
+		ID:          dllID,
+		Conn:        server,
+		IsActive:    true,
+		EnforceChan: make(chan *models.EnforcementMessage, 10),
+	}
+	s.mu.Lock()
+	s.connections[dllID] = dllConn
+	s.mu.Unlock()
+	go s.handleConnection(dllConn)
+	t.Cleanup(func() { terminal.Close() })
+	return terminal, dllConn
+}
+
+func sendFrame(t *testing.T, conn net.Conn, event *models.MT5Event) {
+	t.Helper()
+
+	data, err := event.Serialize()
+	if err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	frame := binary.LittleEndian.AppendUint32(nil, uint32(len(data)))
+	if _, err := conn.Write(append(frame, data...)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+}
+
+func TestEnforcementReachesTheUsersTerminal(t *testing.T) {
+	repo, fake := newTestRepo(t)
+	sinkA := &acceptAll{events: make(chan *models.MT5Event, 10)}
+	instanceA := NewDLLService(sinkA, repo, BackpressureDropNewest, time.Second, NewDeadLetterService(repo))
+	instanceB := NewDLLService(&acceptAll{events: make(chan *models.MT5Event, 10)}, repo, BackpressureDropNewest, time.Second, NewDeadLetterService(repo))
+	instanceA.StartRelay()
+	instanceB.StartRelay()
+	t.Cleanup(func() {
+		close(instanceA.relayDone)
+		close(instanceB.relayDone)
+	})
+
+	terminalA, connA := connect(t, instanceA, "dll-a")
+	_, otherA := connect(t, instanceA, "dll-other")
+	sendFrame(t, terminalA, &models.MT5Event{UserId: "alice", EventType: models.EventOrderClose, Sequence: 1})
+	<-sinkA.events
+
+	deadline := time.Now().Add(time.Second)
+	for fake.Subscribers("enforcements:relay") < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("relays did not subscribe")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// Processed on B, which holds no connection for alice.
+	instanceB.SendEnforcement(&models.EnforcementMessage{UserId: "alice", Action: "close_all"})
+	select {
+	case enforcement := <-connA.EnforceChan:
+		if enforcement.UserId != "alice" || enforcement.Action != "close_all" {
+			t.Errorf("relayed enforcement = %+v", enforcement)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("enforcement processed on another instance never reached alice's DLL")
+	}
+
+	published := fake.Count("PUBLISH")
+	instanceA.SendEnforcement(&models.EnforcementMessage{UserId: "alice", Action: "block_trading"})
+	if enforcement := <-connA.EnforceChan; enforcement.Action != "block_trading" {
+		t.Errorf("local enforcement = %+v", enforcement)
+	}
+	if fake.Count("PUBLISH") != published {
+		t.Error("a local enforcement was relayed as well")
+	}
+
+	instanceA.SendEnforcement(&models.EnforcementMessage{UserId: "bob", Action: "close_all"})
+	time.Sleep(50 * time.Millisecond)
+	if len(connA.EnforceChan) != 0 || len(otherA.EnforceChan) != 0 {
+		t.Error("enforcement for a user with no known DLL was sent to an unrelated DLL")
+	}
+}
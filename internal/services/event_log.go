@@ -0,0 +1,307 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+const (
+	replaySource  = "replay"
+	staleEventAge = time.Minute
+)
+
+var errEventServiceStopped = errors.New("event service stopped")
+
+func (s *EventService) accept(source string, event *models.MT5Event) {
+	// Stamped before the append so windows, replays and backtests all see
+	// the same time for an event the terminal sent without one.
+	if event.Timestamp == 0 {
+		event.Timestamp = time.Now().Unix()
+	}
+	AssignEventID(source, event)
+	if !s.dedup.FirstSeen(source, event) {
+		s.release()
+		return
+	}
+
+	if _, err := s.history.Append(source, event); err != nil {
+		if !s.history.Owns(event.UserId) {
+			s.dedup.Forget(event)
+			raw, _ := event.Serialize()
+			s.deadLetters.Record(source, models.DeadLetterProcess, raw, event, fmt.Errorf("event log unavailable for a user owned by another instance: %w", err))
+			s.release()
+			return
+		}
+		log.Printf("Failed to append event for user %s to the event log, processing it without durability: %v", event.UserId, err)
+		s.enqueue(queuedEvent{source: source, event: event, slot: true})
+		return
+	}
+	if !s.history.Owns(event.UserId) {
+		s.release()
+	}
+}
+
+func (s *EventService) enqueue(item queuedEvent) bool {
+	item = s.track(item)
+	select {
+	case s.partitionFor(item.event.UserId) <- item:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *EventService) consume() {
+	if err := s.history.Join(); err != nil {
+		log.Printf("Failed to join event log consumer group: %v", err)
+	}
+	s.recoverPending()
+	if !s.claimStale() {
+		return
+	}
+	lastClaim := time.Now()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if time.Since(lastClaim) >= staleEventAge {
+			if !s.claimStale() {
+				return
+			}
+			lastClaim = time.Now()
+		}
+
+		entries, err := s.history.Next(100, time.Second)
+		if err != nil {
+			log.Printf("Failed to read event log: %v", err)
+			select {
+			case <-time.After(time.Second):
+			case <-s.done:
+				return
+			}
+			continue
+		}
+
+		for _, entry := range entries {
+			if !s.dispatch(entry) {
+				return
+			}
+		}
+	}
+}
+
+func (s *EventService) recoverPending() {
+	after := "0"
+	recovered := 0
+	for {
+		entries, err := s.history.Pending(after, 100)
+		if err != nil {
+			log.Printf("Failed to read unacknowledged events: %v", err)
+			return
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			if !s.dispatch(entry) {
+				return
+			}
+			after = entry.ID
+		}
+		recovered += len(entries)
+	}
+
+	if recovered > 0 {
+		log.Printf("Resumed %d unacknowledged events from the event log", recovered)
+	}
+}
+
+// claimStale takes over entries left pending by consumers that are gone,
+// such as an earlier run of this instance under a different consumer name.
+func (s *EventService) claimStale() bool {
+	start := "0-0"
+	claimed := 0
+	for {
+		entries, next, err := s.history.Claim(start, staleEventAge, 100)
+		if err != nil {
+			log.Printf("Failed to claim stale events: %v", err)
+			return true
+		}
+		for _, entry := range entries {
+			if !s.dispatch(entry) {
+				return false
+			}
+		}
+		claimed += len(entries)
+		if next == "" || next == "0-0" {
+			break
+		}
+		start = next
+	}
+
+	if claimed > 0 {
+		log.Printf("Claimed %d stale events from other consumers", claimed)
+	}
+	return true
+}
+
+func (s *EventService) dispatch(entry *models.EventLogEntry) bool {
+	if entry.Event == nil || !s.history.Owns(entry.Event.UserId) {
+		if entry.Event == nil {
+			log.Printf("Skipping unreadable event log entry %s", entry.ID)
+		}
+		if err := s.history.Ack(entry.ID); err != nil {
+			log.Printf("Failed to acknowledge event %s: %v", entry.ID, err)
+		}
+		return true
+	}
+	return s.enqueue(queuedEvent{
+		id:     entry.ID,
+		source: entry.Source,
+		event:  entry.Event,
+		slot:   entry.Origin == s.history.Origin(),
+	})
+}
+
+func (s *EventService) fail(item queuedEvent, cause error) {
+	switch {
+	case item.scheduled:
+		log.Printf("Scheduled rules failed for user %s: %v", item.event.UserId, cause)
+	case item.deadLetter != nil:
+		s.deadLetters.Fail(item.deadLetter, cause)
+	default:
+		raw, _ := item.event.Serialize()
+		s.deadLetters.Record(item.source, models.DeadLetterProcess, raw, item.event, cause)
+	}
+}
+
+func (s *EventService) RetryDeadLetter(id string, req *dto.DeadLetterRetryRequest) (*models.DeadLetter, error) {
+	letter, err := s.deadLetters.Get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case req.Event != nil:
+		letter.Event = req.Event
+		letter.Raw, _ = req.Event.Serialize()
+	case len(req.Raw) > 0:
+		letter.Raw = req.Raw
+		letter.Event = nil
+	}
+
+	if letter.Event == nil {
+		event := &models.MT5Event{}
+		if err := event.Deserialize(letter.Raw); err != nil {
+			err = fmt.Errorf("%w: %v", ErrInvalidEvent, err)
+			s.deadLetters.Fail(letter, err)
+			return letter, err
+		}
+		letter.Event = event
+	}
+	if err := ValidateEvent(letter.Event); err != nil {
+		s.deadLetters.Fail(letter, err)
+		return letter, err
+	}
+
+	AssignEventID(letter.Source, letter.Event)
+	if !s.history.Owns(letter.Event.UserId) {
+		if _, err := s.history.Append(letter.Source, letter.Event); err != nil {
+			return letter, err
+		}
+		s.deadLetters.Resolve(letter)
+		return letter, nil
+	}
+
+	select {
+	case s.inflight <- struct{}{}:
+	default:
+		return letter, ErrQueueFull
+	}
+
+	if !s.enqueue(queuedEvent{source: letter.Source, event: letter.Event, deadLetter: letter, slot: true}) {
+		return letter, errEventServiceStopped
+	}
+	return letter, nil
+}
+
+// replayRun follows the events one Replay call queued until each of them
+// has been applied, skipped or dropped.
+type replayRun struct {
+	pending sync.WaitGroup
+	applied atomic.Int64
+	skipped atomic.Int64
+}
+
+func (r *replayRun) record(applied bool) {
+	if applied {
+		r.applied.Add(1)
+	} else {
+		r.skipped.Add(1)
+	}
+}
+
+// ReplayReport counts the replayed events the state did not hold yet
+// (Applied), those it already held (Skipped), and those that were neither,
+// because drop_oldest evicted them, processing failed or the engine stopped
+// first (Dropped).
+type ReplayReport struct {
+	Applied int
+	Skipped int
+	Dropped int
+}
+
+// Replay queues the logged events of the owned users in the time range and
+// waits until they have been processed.
+func (s *EventService) Replay(from, to int64, userID string) (*ReplayReport, error) {
+	run := &replayRun{}
+	queued := 0
+	err := s.history.Scan(from, to, func(entry *models.EventLogEntry) error {
+		event := entry.Event
+		if userID != "" && event.UserId != userID || !s.history.Owns(event.UserId) {
+			return nil
+		}
+
+		select {
+		case s.inflight <- struct{}{}:
+		case <-s.done:
+			return errEventServiceStopped
+		}
+		run.pending.Add(1)
+		if !s.enqueue(queuedEvent{id: entry.ID, source: replaySource, event: event, slot: true, replay: run}) {
+			run.pending.Done()
+			return errEventServiceStopped
+		}
+		queued++
+		return nil
+	})
+
+	finished := make(chan struct{})
+	go func() {
+		run.pending.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-s.done:
+		if err == nil {
+			err = errEventServiceStopped
+		}
+	}
+
+	report := &ReplayReport{Applied: int(run.applied.Load()), Skipped: int(run.skipped.Load())}
+	report.Dropped = queued - report.Applied - report.Skipped
+	return report, err
+}
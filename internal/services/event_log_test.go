@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestConsumeAppliesAndAcksEvents(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 2)
+	e.start(t)
+	user := userFor(e.history, true)
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		if !e.events.TrySubmit("dll-1", testOpen(user, seq)) {
+			t.Fatalf("TrySubmit %d refused", seq)
+		}
+	}
+
+	eventually(t, "three events applied", func() bool { return dayVolume(e.users, user) == 3 })
+	eventually(t, "entries acknowledged", func() bool { return e.fake.Pending(e.history.group) == 0 })
+	eventually(t, "slots released", func() bool { return len(e.events.inflight) == 0 })
+}
+
+func TestConsumeSkipsUsersOfOtherInstances(t *testing.T) {
+	e := newTestEngine(t, 0, 2, 8, 1)
+	e.start(t)
+	other := userFor(e.history, false)
+
+	if !e.events.TrySubmit("dll-1", testOpen(other, 1)) {
+		t.Fatal("TrySubmit refused")
+	}
+	eventually(t, "entry acknowledged", func() bool {
+		return e.fake.Count("XACK") > 0 && e.fake.Pending(e.history.group) == 0
+	})
+	if state := e.users.GetUserState(other); state != nil {
+		t.Errorf("instance 0 applied an event for %s, which instance 1 owns", other)
+	}
+	if n := len(e.events.inflight); n != 0 {
+		t.Errorf("%d slots still held", n)
+	}
+}
+
+func TestNewGroupReadsExistingBacklog(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	user := userFor(e.history, true)
+
+	// Appended while no group for this instance count existed yet.
+	for seq := uint64(1); seq <= 2; seq++ {
+		if _, err := e.history.Append("dll-1", testOpen(user, seq)); err != nil {
+			t.Fatalf("Append: %v", err)
+		}
+	}
+	e.start(t)
+
+	eventually(t, "backlog applied", func() bool { return dayVolume(e.users, user) == 2 })
+}
+
+func TestRestartResumesUnacknowledgedEventsOnce(t *testing.T) {
+	first := newTestEngine(t, 0, 1, 8, 1)
+	user := userFor(first.history, true)
+	if err := first.history.Join(); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		first.events.TrySubmit("dll-1", testOpen(user, seq))
+	}
+
+	// The first run reads both, applies one and stops before acknowledging.
+	entries, err := first.history.Next(10, time.Millisecond)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("Next = %d entries, %v, want 2", len(entries), err)
+	}
+	state := first.users.CreateUserState(user)
+	first.users.UpdateUserStateWithEvent(state, entries[0].Event, entries[0].ID)
+	first.users.Flush(context.Background())
+	if pending := first.fake.Pending(first.history.group); pending != 2 {
+		t.Fatalf("%d entries pending, want 2", pending)
+	}
+
+	second := newTestEngineOn(t, first.repo, first.fake, 0, 1, 8, 1)
+	second.start(t)
+
+	eventually(t, "pending entries acknowledged", func() bool { return second.fake.Pending(second.history.group) == 0 })
+	if volume := dayVolume(second.users, user); volume != 2 {
+		t.Errorf("DayVolume = %v after resuming, want 2", volume)
+	}
+}
+
+func TestClaimStaleTakesOverAbandonedEntries(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	user := userFor(e.history, true)
+	if err := e.history.Join(); err != nil {
+		t.Fatalf("Join: %v", err)
+	}
+	e.events.TrySubmit("dll-1", testOpen(user, 1))
+
+	// Delivered to a consumer name that no longer runs.
+	if _, err := e.repo.ReadEventGroup(e.history.group, "engine-old", ">", 10, -1); err != nil {
+		t.Fatalf("ReadEventGroup: %v", err)
+	}
+	e.fake.Age(2 * staleEventAge)
+	e.start(t)
+
+	eventually(t, "stale entry applied", func() bool { return dayVolume(e.users, user) == 1 })
+	eventually(t, "stale entry acknowledged", func() bool { return e.fake.Pending(e.history.group) == 0 })
+}
+
+func TestReplayReportsAppliedAndSkippedEvents(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	e.start(t)
+	user := userFor(e.history, true)
+	from := time.Now().Unix() - 1
+
+	for seq := uint64(1); seq <= 3; seq++ {
+		e.events.TrySubmit("dll-1", testOpen(user, seq))
+	}
+	eventually(t, "three events applied", func() bool { return dayVolume(e.users, user) == 3 })
+
+	var first string
+	e.history.Scan(from, time.Now().Unix()+1, func(entry *models.EventLogEntry) error {
+		if first == "" {
+			first = entry.ID
+		}
+		return nil
+	})
+
+	// State restored from a snapshot taken after the first event only.
+	state := e.users.GetUserState(user)
+	state.Mu.Lock()
+	state.LastEventID = first
+	state.DayVolume = 1
+	state.Mu.Unlock()
+
+	report, err := e.events.Replay(from, time.Now().Unix()+1, user)
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if *report != (ReplayReport{Applied: 2, Skipped: 1}) {
+		t.Errorf("Replay = %+v, want 2 applied and 1 skipped", *report)
+	}
+	if volume := dayVolume(e.users, user); volume != 3 {
+		t.Errorf("DayVolume = %v after replay, want 3", volume)
+	}
+}
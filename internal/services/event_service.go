@@ -1,7 +1,11 @@
 package services
 
 import (
+	"fmt"
+	"hash/fnv"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/NOTMKW/DLLBEL/internal/models"
@@ -10,37 +14,98 @@ import (
 type EventService struct {
 	ruleService *RuleService
 	userService *UserService
+	windows     *WindowService
 	dllService  *DLLService
 	wsService   *WebSocketService
-	eventChan   chan *models.MT5Event
+	shadow      *ShadowService
+	history     *HistoryService
+	cooldowns   *CooldownService
+	dedup       *DedupService
+	deadLetters *DeadLetterService
+	partitions  []chan queuedEvent
+	inflight    chan struct{}
+	evictMu     sync.Mutex
+	evictable   []queuedEvent
 	done        chan bool
+	running     sync.WaitGroup
+	processed   atomic.Int64
 }
 
-func NewEventService(ruleService *RuleService, userService *UserService, dllService *DLLService, wsService *WebSocketService, bufferSize int) *EventService {
+type queuedEvent struct {
+	id         string
+	source     string
+	event      *models.MT5Event
+	scheduled  bool
+	slot       bool
+	deadLetter *models.DeadLetter
+	replay     *replayRun
+	taken      *atomic.Bool
+}
+
+// start claims a queued item for processing. It fails for an item that
+// drop_oldest evicted while it was still waiting in its partition.
+func (item queuedEvent) start() bool {
+	return item.taken == nil || item.taken.CompareAndSwap(false, true)
+}
+
+func NewEventService(ruleService *RuleService, userService *UserService, windows *WindowService, dllService *DLLService, wsService *WebSocketService, shadow *ShadowService, history *HistoryService, cooldowns *CooldownService, dedup *DedupService, deadLetters *DeadLetterService, bufferSize, workers int) *EventService {
+	if workers < 1 {
+		workers = 1
+	}
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	partitions := make([]chan queuedEvent, workers)
+	for i := range partitions {
+		partitions[i] = make(chan queuedEvent, bufferSize)
+	}
+
 	return &EventService{
 		ruleService: ruleService,
 		userService: userService,
+		windows:     windows,
 		dllService:  dllService,
 		wsService:   wsService,
-		eventChan:   make(chan *models.MT5Event, bufferSize),
+		shadow:      shadow,
+		history:     history,
+		cooldowns:   cooldowns,
+		dedup:       dedup,
+		deadLetters: deadLetters,
+		partitions:  partitions,
+		inflight:    make(chan struct{}, bufferSize),
 		done:        make(chan bool),
 	}
 }
 
-func (s *EventService) Start(workers int) {
-	for i := 0; i < workers; i++ {
-		go func() {
+func (s *EventService) Start() {
+	for _, partition := range s.partitions {
+		s.running.Add(1)
+		go func(queue chan queuedEvent) {
+			defer s.running.Done()
 			for {
 				select {
-				case event := <-s.eventChan:
-					s.processEvent(event)
+				case item := <-queue:
+					if !item.start() {
+						continue
+					}
+					s.handle(item)
+					s.complete(item)
+					if !item.scheduled {
+						s.processed.Add(1)
+					}
 				case <-s.done:
 					return
 				}
 			}
-		}()
+		}(partition)
 	}
-	log.Printf("Event service started with %d workers", workers)
+	s.running.Add(1)
+	go func() {
+		defer s.running.Done()
+		s.consume()
+	}()
+	log.Printf("Event service started with %d partitions", len(s.partitions))
 }
 
 func (s *EventService) Stop() {
@@ -48,37 +113,277 @@ func (s *EventService) Stop() {
 	log.Println("Event service stopped")
 }
 
-func (s *EventService) GetEventChannel() chan *models.MT5Event {
-	return s.eventChan
+func userHash(userID string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(userID))
+	return h.Sum32()
+}
+
+func (s *EventService) partitionFor(userID string) chan queuedEvent {
+	return s.partitions[userHash(userID)%uint32(len(s.partitions))]
+}
+
+func (s *EventService) TrySubmit(source string, event *models.MT5Event) bool {
+	select {
+	case s.inflight <- struct{}{}:
+		s.accept(source, event)
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *EventService) SubmitWait(source string, event *models.MT5Event, timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case s.inflight <- struct{}{}:
+		s.accept(source, event)
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// SubmitEvict makes room for the event by evicting the oldest queued events
+// that hold a slot. When none can be evicted, because the slots belong to
+// events still on their way through the event log, it waits up to timeout
+// and reports whether the event was accepted.
+func (s *EventService) SubmitEvict(source string, event *models.MT5Event, timeout time.Duration) ([]queuedEvent, bool) {
+	evicted := []queuedEvent{}
+	for {
+		select {
+		case s.inflight <- struct{}{}:
+			s.accept(source, event)
+			return evicted, true
+		default:
+		}
+
+		old, ok := s.evictOldest(event.UserId)
+		if !ok {
+			return evicted, s.SubmitWait(source, event, timeout)
+		}
+		s.complete(old)
+		evicted = append(evicted, old)
+	}
+}
+
+// track records a queued item that holds a slot so that drop_oldest can
+// evict it without disturbing the order of its partition.
+func (s *EventService) track(item queuedEvent) queuedEvent {
+	if !item.slot || item.scheduled {
+		return item
+	}
+	item.taken = new(atomic.Bool)
+
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+	if len(s.evictable) >= 2*cap(s.inflight) {
+		pending := s.evictable[:0]
+		for _, queued := range s.evictable {
+			if !queued.taken.Load() {
+				pending = append(pending, queued)
+			}
+		}
+		s.evictable = pending
+	}
+	s.evictable = append(s.evictable, item)
+	return item
+}
+
+// evictOldest takes the oldest queued item that holds a slot, preferring
+// the user's own partition. The item stays in its partition and is skipped
+// when a worker reaches it.
+func (s *EventService) evictOldest(userID string) (queuedEvent, bool) {
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+
+	partition := userHash(userID) % uint32(len(s.partitions))
+	for _, sameOnly := range []bool{true, false} {
+		for i, queued := range s.evictable {
+			if sameOnly && userHash(queued.event.UserId)%uint32(len(s.partitions)) != partition {
+				continue
+			}
+			if queued.taken.CompareAndSwap(false, true) {
+				s.evictable = append(s.evictable[:i], s.evictable[i+1:]...)
+				return queued, true
+			}
+		}
+	}
+	return queuedEvent{}, false
+}
+
+func (s *EventService) complete(item queuedEvent) {
+	if item.id != "" && item.source != replaySource {
+		if err := s.history.Ack(item.id); err != nil {
+			log.Printf("Failed to acknowledge event %s: %v", item.id, err)
+		}
+	}
+	if item.slot {
+		s.release()
+	}
+	if item.replay != nil {
+		item.replay.pending.Done()
+	}
+}
+
+func (s *EventService) release() {
+	select {
+	case <-s.inflight:
+	default:
+	}
+}
+
+func (s *EventService) Duplicates() (int64, map[string]int64) {
+	return s.dedup.Duplicates()
+}
+
+func (s *EventService) QueueDepths() []int {
+	depths := make([]int, len(s.partitions))
+	for i, partition := range s.partitions {
+		depths[i] = len(partition)
+	}
+	return depths
+}
+
+func (s *EventService) handle(item queuedEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.fail(item, fmt.Errorf("panic while processing event: %v", r))
+		}
+	}()
+
+	if item.scheduled {
+		if state := s.userService.GetUserState(item.event.UserId); state != nil {
+			s.applyRules(item.event, state, true)
+		}
+		return
+	}
+
+	s.processEvent(item)
+	if item.deadLetter != nil {
+		s.deadLetters.Resolve(item.deadLetter)
+	}
 }
 
-func (s *EventService) processEvent(event *models.MT5Event) {
+func (s *EventService) processEvent(item queuedEvent) {
+	event := item.event
 	userState := s.userService.GetUserState(event.UserId)
 	if userState == nil {
 		userState = s.userService.CreateUserState(event.UserId)
 	}
-	s.userService.UpdateUserStateWithEvent(userState, event)
+	applied := s.userService.UpdateUserStateWithEvent(userState, event, item.id)
+	if item.replay != nil {
+		item.replay.record(applied)
+	}
+	if !applied {
+		return
+	}
+	s.windows.Record(event)
 
-	rules, err := s.ruleService.GetAllRules()
-	if err != nil {
-		log.Printf("Failed to get rules: %v", err)
+	// A replay only rebuilds state the engine missed; its enforcements were
+	// already decided when the events first arrived.
+	if item.source == replaySource {
 		return
 	}
+	s.applyRules(event, userState, false)
+}
 
-	for _, rule := range rules {
-		if rule.Enabled && s.ruleService.EvaluateRule(rule, event, userState) {
-			for _, action := range rule.Actions {
-				enforcement := &models.EnforcementMessage{
-					UserId:    event.UserId,
-					Action:    action.Type,
-					Reason:    "Rule violation: " + rule.Name,
-					Severity:  action.Severity,
-					Timestamp: time.Now().Unix(),
-				}
-				s.dllService.SendEnforcement(enforcement)
-				s.wsService.SendEnforcement(enforcement)
-				log.Printf("Enforcement action '%s' triggered for user %s due to rule '%s'", action.Type, event.UserId, rule.Name)
+func (s *EventService) StartScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.runScheduledRules()
+			case <-s.done:
+				return
 			}
 		}
+	}()
+	log.Printf("Rule scheduler started with %s interval", interval)
+}
+
+func (s *EventService) runScheduledRules() {
+	now := time.Now().Unix()
+	if pruned := s.windows.Prune(now); pruned > 0 {
+		log.Printf("Pruned idle event windows for %d users", pruned)
+	}
+	for _, state := range s.userService.AllStates() {
+		// States of other instances' users are loaded for reads through the
+		// admin API; their owner runs the scheduled rules for them.
+		if !s.history.Owns(state.UserID) {
+			continue
+		}
+		tick := &models.MT5Event{
+			UserId:    state.UserID,
+			EventType: models.EventScheduleTick,
+			Timestamp: now,
+		}
+
+		select {
+		case s.partitionFor(state.UserID) <- queuedEvent{event: tick, scheduled: true}:
+		default:
+			log.Printf("Partition full, skipping scheduled rules for user %s", state.UserID)
+		}
+	}
+}
+
+func (s *EventService) applyRules(event *models.MT5Event, userState *models.UserState, scheduled bool) {
+	for _, rule := range s.ruleService.Rules() {
+		if rule.IsScheduled() != scheduled {
+			continue
+		}
+
+		mode := rule.EffectiveMode()
+		if mode == models.RuleModeDisabled || !s.ruleService.Evaluate(rule, event, userState) {
+			continue
+		}
+
+		if s.cooldowns.Allow(rule.Rule, mode, event) {
+			s.fire(rule, mode, event, userState)
+		}
+
+		if rule.Final && mode == models.RuleModeEnforce {
+			break
+		}
+	}
+}
+
+func (s *EventService) fire(rule *CompiledRule, mode string, event *models.MT5Event, state *models.UserState) {
+	now := time.Now().Unix()
+
+	var violation models.ViolationCounter
+	if mode == models.RuleModeShadow {
+		violation = s.userService.PeekViolation(state, rule.Rule, now)
+	} else {
+		violation = s.userService.RecordViolation(state, rule.Rule, now)
+	}
+
+	explanation := s.ruleService.Explain(rule, event, state)
+	reason := ExplanationReason(explanation)
+
+	for _, action := range s.ruleService.ActionsForStep(rule.Rule, violation.Step) {
+		enforcement := &models.EnforcementMessage{
+			UserId:         event.UserId,
+			Action:         action.Type,
+			Reason:         reason,
+			Severity:       action.Severity,
+			Timestamp:      now,
+			EscalationStep: violation.Step,
+			Explanation:    explanation,
+		}
+
+		if mode == models.RuleModeShadow {
+			s.shadow.Record(rule.Rule, event, enforcement)
+			continue
+		}
+
+		s.userService.RecordEnforcement(enforcement)
+		s.dllService.SendEnforcement(enforcement)
+		s.wsService.SendEnforcement(enforcement)
+		log.Printf("Enforcement action '%s' triggered for user %s due to rule '%s'", action.Type, event.UserId, rule.Name)
 	}
 }
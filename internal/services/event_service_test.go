@@ -0,0 +1,157 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+// start runs the event service until the test ends.
+func (e *testEngine) start(t *testing.T) {
+	e.events.Start()
+	t.Cleanup(e.events.Stop)
+}
+
+// eventually polls cond until it holds or a second has passed.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func dayVolume(users *UserService, user string) float64 {
+	state := users.GetUserState(user)
+	if state == nil {
+		return 0
+	}
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
+	return state.DayVolume
+}
+
+type testEngine struct {
+	repo        *repository.RedisRepository
+	fake        *fakeRedis
+	rules       *RuleService
+	users       *UserService
+	windows     *WindowService
+	dll         *DLLService
+	history     *HistoryService
+	cooldowns   *CooldownService
+	dedup       *DedupService
+	deadLetters *DeadLetterService
+	events      *EventService
+}
+
+// newTestEngine wires the services the way the server does, against a
+// fake Redis. The event service is not started.
+func newTestEngine(t *testing.T, instance, instances, bufferSize, workers int) *testEngine {
+	t.Helper()
+
+	repo, fake := newTestRepo(t)
+	return newTestEngineOn(t, repo, fake, instance, instances, bufferSize, workers)
+}
+
+// newTestEngineOn wires a second engine against the same Redis, as after a
+// restart or on another instance.
+func newTestEngineOn(t *testing.T, repo *repository.RedisRepository, fake *fakeRedis, instance, instances, bufferSize, workers int) *testEngine {
+	t.Helper()
+
+	clock, err := NewBrokerClock("UTC", "00:00")
+	if err != nil {
+		t.Fatalf("NewBrokerClock: %v", err)
+	}
+
+	e := &testEngine{repo: repo, fake: fake}
+	e.windows = NewWindowService(1000, 3600)
+	e.rules = NewRuleService(repo, e.windows, clock)
+	e.users = NewUserService(repo, clock)
+	ws := NewWebSocketService()
+	e.deadLetters = NewDeadLetterService(repo)
+	e.dll = NewDLLService(nil, repo, BackpressureBlock, time.Second, e.deadLetters)
+	e.history = NewHistoryService(repo, 10000, instance, instances)
+	e.cooldowns = NewCooldownService(repo)
+	e.dedup = NewDedupService(repo, time.Hour)
+	e.events = NewEventService(e.rules, e.users, e.windows, e.dll, ws, NewShadowService(repo, ws), e.history, e.cooldowns, e.dedup, e.deadLetters, bufferSize, workers)
+	e.dll.SetEventSink(e.events)
+	return e
+}
+
+// userFor returns a user that the given instance owns, or does not own.
+func userFor(history *HistoryService, owned bool) string {
+	for i := 0; ; i++ {
+		user := fmt.Sprintf("user-%d", i)
+		if history.Owns(user) == owned {
+			return user
+		}
+	}
+}
+
+func TestAcceptForgetsEventThatFailedToAppend(t *testing.T) {
+	e := newTestEngine(t, 0, 2, 8, 1)
+	user := userFor(e.history, false)
+	event := func() *models.MT5Event {
+		return &models.MT5Event{UserId: user, EventType: models.EventOrderOpen, Ticket: 42, Symbol: "EURUSD", Volume: 1}
+	}
+
+	e.fake.FailCommand("XADD", fmt.Errorf("ERR event log unavailable"))
+	if !e.events.TrySubmit("dll-1", event()) {
+		t.Fatal("TrySubmit refused the first event")
+	}
+	letters, err := e.deadLetters.List(10)
+	if err != nil || len(letters) != 1 {
+		t.Fatalf("dead letters = %d, %v, want the failed event", len(letters), err)
+	}
+
+	e.fake.FailCommand("XADD", nil)
+	if !e.events.TrySubmit("dll-1", event()) {
+		t.Fatal("TrySubmit refused the resend")
+	}
+	if total, _ := e.dedup.Duplicates(); total != 0 {
+		t.Errorf("resend was suppressed as a duplicate (%d duplicates)", total)
+	}
+	if n := e.fake.Count("XADD"); n != 2 {
+		t.Errorf("XADD called %d times, want 2", n)
+	}
+	if len(e.events.inflight) != 0 {
+		t.Errorf("%d inflight slots held for events of another instance", len(e.events.inflight))
+	}
+}
+
+func TestSchedulerTicksStoredStatesOfOwnedUsersOnly(t *testing.T) {
+	e := newTestEngine(t, 0, 2, 8, 1)
+	owned, other := userFor(e.history, true), userFor(e.history, false)
+	for _, user := range []string{owned, other} {
+		if err := e.repo.SaveUserState(&models.UserState{UserID: user}); err != nil {
+			t.Fatalf("SaveUserState: %v", err)
+		}
+	}
+
+	loaded, err := e.users.LoadStates(e.history.Owns)
+	if err != nil || loaded != 1 {
+		t.Fatalf("LoadStates = %d, %v, want the owned user only", loaded, err)
+	}
+	// Read through the admin API, so in memory without being owned.
+	e.users.GetUserState(other)
+
+	e.events.runScheduledRules()
+	select {
+	case item := <-e.events.partitionFor(owned):
+		if item.event.UserId != owned || !item.scheduled {
+			t.Errorf("queued %+v, want a scheduled tick for %s", item.event, owned)
+		}
+	default:
+		t.Fatalf("no tick queued for %s, whose state was only in Redis", owned)
+	}
+	if n := len(e.events.partitions[0]); n != 0 {
+		t.Errorf("%d more ticks queued, want none for %s", n, other)
+	}
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+var pendingOrderTypes = map[string]string{
+	models.OrderBuyLimit:      models.SideBuy,
+	models.OrderSellLimit:     models.SideSell,
+	models.OrderBuyStop:       models.SideBuy,
+	models.OrderSellStop:      models.SideSell,
+	models.OrderBuyStopLimit:  models.SideBuy,
+	models.OrderSellStopLimit: models.SideSell,
+}
+
+var eventValidators = map[string]func(*models.MT5Event) error{
+	models.EventOrderOpen: func(e *models.MT5Event) error {
+		if err := requireTrade(e); err != nil {
+			return err
+		}
+		return checkProtection(e)
+	},
+	models.EventOrderClose: func(e *models.MT5Event) error {
+		return requireNonNegative("volume", e.Volume)
+	},
+	models.EventOrderModify: func(e *models.MT5Event) error {
+		if err := requirePosition(e); err != nil {
+			return err
+		}
+		return checkProtection(e)
+	},
+	models.EventPartialClose: func(e *models.MT5Event) error {
+		if err := requirePosition(e); err != nil {
+			return err
+		}
+		return requirePositive("volume", e.Volume)
+	},
+	models.EventPendingPlace: func(e *models.MT5Event) error {
+		side, ok := pendingOrderTypes[e.OrderType]
+		if !ok {
+			return fmt.Errorf("%w: unknown pending order_type %q", ErrInvalidEvent, e.OrderType)
+		}
+		if e.Side == "" {
+			e.Side = side
+		}
+		if e.Side != side {
+			return fmt.Errorf("%w: side %q does not match order_type %q", ErrInvalidEvent, e.Side, e.OrderType)
+		}
+		if err := requireTrade(e); err != nil {
+			return err
+		}
+		if err := requirePositive("price", e.Price); err != nil {
+			return err
+		}
+		return checkProtection(e)
+	},
+	models.EventPendingCancel: func(e *models.MT5Event) error {
+		if e.Ticket == 0 {
+			return fmt.Errorf("%w: ticket is required", ErrInvalidEvent)
+		}
+		return nil
+	},
+	models.EventBalanceUpdate: func(e *models.MT5Event) error { return nil },
+	models.EventEquityUpdate:  func(e *models.MT5Event) error { return nil },
+	models.EventDeposit: func(e *models.MT5Event) error {
+		return requirePositive("amount", e.Amount)
+	},
+	models.EventWithdrawal: func(e *models.MT5Event) error {
+		return requirePositive("amount", e.Amount)
+	},
+	models.EventMarginCall: func(e *models.MT5Event) error {
+		return requireNonNegative("margin_level", e.MarginLevel)
+	},
+	models.EventStopOut: func(e *models.MT5Event) error {
+		return requireNonNegative("margin_level", e.MarginLevel)
+	},
+	models.EventLogin:  func(e *models.MT5Event) error { return nil },
+	models.EventLogout: func(e *models.MT5Event) error { return nil },
+}
+
+func ValidateEvent(event *models.MT5Event) error {
+	switch {
+	case event.UserId == "":
+		return fmt.Errorf("%w: user_id is required", ErrInvalidEvent)
+	case event.EventType == "":
+		return fmt.Errorf("%w: event_type is required", ErrInvalidEvent)
+	}
+
+	validate, ok := eventValidators[event.EventType]
+	if !ok {
+		return fmt.Errorf("%w: unknown event_type %q", ErrInvalidEvent, event.EventType)
+	}
+	if err := requireNonNegative("sl", event.StopLoss); err != nil {
+		return err
+	}
+	if err := requireNonNegative("tp", event.TakeProfit); err != nil {
+		return err
+	}
+	return validate(event)
+}
+
+func requireTrade(e *models.MT5Event) error {
+	if e.Symbol == "" {
+		return fmt.Errorf("%w: symbol is required", ErrInvalidEvent)
+	}
+	if e.Side != "" && e.Side != models.SideBuy && e.Side != models.SideSell {
+		return fmt.Errorf("%w: side must be %q or %q", ErrInvalidEvent, models.SideBuy, models.SideSell)
+	}
+	return requirePositive("volume", e.Volume)
+}
+
+func requirePosition(e *models.MT5Event) error {
+	if e.Ticket == 0 && e.PositionID == 0 {
+		return fmt.Errorf("%w: ticket or position_id is required", ErrInvalidEvent)
+	}
+	if e.EventID == "" && e.Sequence == 0 {
+		return fmt.Errorf("%w: %s needs a sequence or event_id so repeats are not taken for duplicates", ErrInvalidEvent, e.EventType)
+	}
+	return nil
+}
+
+func checkProtection(e *models.MT5Event) error {
+	if e.Price <= 0 {
+		return nil
+	}
+
+	switch e.Side {
+	case models.SideBuy:
+		if e.StopLoss > 0 && e.StopLoss >= e.Price {
+			return fmt.Errorf("%w: sl %g must be below price %g for a buy", ErrInvalidEvent, e.StopLoss, e.Price)
+		}
+		if e.TakeProfit > 0 && e.TakeProfit <= e.Price {
+			return fmt.Errorf("%w: tp %g must be above price %g for a buy", ErrInvalidEvent, e.TakeProfit, e.Price)
+		}
+	case models.SideSell:
+		if e.StopLoss > 0 && e.StopLoss <= e.Price {
+			return fmt.Errorf("%w: sl %g must be above price %g for a sell", ErrInvalidEvent, e.StopLoss, e.Price)
+		}
+		if e.TakeProfit > 0 && e.TakeProfit >= e.Price {
+			return fmt.Errorf("%w: tp %g must be below price %g for a sell", ErrInvalidEvent, e.TakeProfit, e.Price)
+		}
+	}
+	return nil
+}
+
+func requirePositive(field string, value float64) error {
+	if value <= 0 {
+		return fmt.Errorf("%w: %s must be positive", ErrInvalidEvent, field)
+	}
+	return nil
+}
+
+func requireNonNegative(field string, value float64) error {
+	if value < 0 {
+		return fmt.Errorf("%w: %s cannot be negative", ErrInvalidEvent, field)
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+const eventConsumerGroup = "engine"
+
+type HistoryService struct {
+	repo      *repository.RedisRepository
+	maxLen    int64
+	group     string
+	consumer  string
+	origin    string
+	instance  uint32
+	instances uint32
+}
+
+func NewHistoryService(repo *repository.RedisRepository, maxLen int64, instance, instances int) *HistoryService {
+	group := eventConsumerGroup
+	if instances > 1 {
+		group = fmt.Sprintf("%s-%d", eventConsumerGroup, instance)
+	}
+	consumer := fmt.Sprintf("%s-%d", eventConsumerGroup, instance)
+
+	return &HistoryService{
+		repo:      repo,
+		maxLen:    maxLen,
+		group:     group,
+		consumer:  consumer,
+		origin:    fmt.Sprintf("%s:%d", consumer, time.Now().UnixNano()),
+		instance:  uint32(instance),
+		instances: uint32(instances),
+	}
+}
+
+func ValidateInstance(instance, instances int) error {
+	if instances < 1 {
+		return fmt.Errorf("engine instance count must be at least 1, got %d", instances)
+	}
+	if instance < 0 || instance >= instances {
+		return fmt.Errorf("engine instance %d is outside 0..%d", instance, instances-1)
+	}
+	return nil
+}
+
+// Owns reports whether this instance processes the user's events. Every
+// instance reads the whole log through its own group and skips other users,
+// so one user's events are always applied by the same instance, in order.
+func (s *HistoryService) Owns(userID string) bool {
+	return userHash(userID)%s.instances == s.instance
+}
+
+func (s *HistoryService) Origin() string {
+	return s.origin
+}
+
+func (s *HistoryService) Append(source string, event *models.MT5Event) (string, error) {
+	return s.repo.AppendEventHistory(source, s.origin, event, s.maxLen)
+}
+
+func (s *HistoryService) Join() error {
+	return s.repo.CreateEventGroup(s.group)
+}
+
+func (s *HistoryService) Pending(after string, count int64) ([]*models.EventLogEntry, error) {
+	return s.repo.ReadEventGroup(s.group, s.consumer, after, count, -1)
+}
+
+func (s *HistoryService) Claim(start string, minIdle time.Duration, count int64) ([]*models.EventLogEntry, string, error) {
+	return s.repo.ClaimEventGroup(s.group, s.consumer, minIdle, start, count)
+}
+
+func (s *HistoryService) Next(count int64, block time.Duration) ([]*models.EventLogEntry, error) {
+	return s.repo.ReadEventGroup(s.group, s.consumer, ">", count, block)
+}
+
+func (s *HistoryService) Ack(id string) error {
+	return s.repo.AckEvent(s.group, id)
+}
+
+func (s *HistoryService) Scan(from, to int64, fn func(*models.EventLogEntry) error) error {
+	return s.repo.ScanEventHistory(from, to, fn)
+}
+
+// streamIDAfter reports whether log entry id comes after last; an empty last
+// means nothing has been applied yet.
+func streamIDAfter(id, last string) bool {
+	if last == "" {
+		return true
+	}
+	var ms, seq, lastMs, lastSeq uint64
+	fmt.Sscanf(id, "%d-%d", &ms, &seq)
+	fmt.Sscanf(last, "%d-%d", &lastMs, &lastSeq)
+	return ms > lastMs || ms == lastMs && seq > lastSeq
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"sort"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+const volumeEpsilon = 1e-9
+
+func positionKey(event *models.MT5Event) int64 {
+	if event.PositionID != 0 {
+		return event.PositionID
+	}
+	return event.Ticket
+}
+
+func (s *UserService) applyPositionEvent(state *models.UserState, event *models.MT5Event, eventTime int64) {
+	key := positionKey(event)
+	if key == 0 {
+		// Terminals that predate position tickets only let us keep a count.
+		switch event.EventType {
+		case models.EventOrderOpen:
+			state.OpenPositions += 1
+		case models.EventOrderClose:
+			if state.OpenPositions > 0 {
+				state.OpenPositions -= 1
+			}
+		}
+		return
+	}
+
+	if state.Positions == nil {
+		state.Positions = make(map[int64]*models.Position)
+	}
+	position, held := state.Positions[key]
+
+	switch event.EventType {
+	case models.EventOrderOpen:
+		if held {
+			// Netting accounts report a deal added to an open position under
+			// the position's ticket.
+			addToPosition(position, event)
+			return
+		}
+		side := event.Side
+		if side == "" {
+			side = pendingOrderTypes[event.OrderType]
+		}
+		state.Positions[key] = &models.Position{
+			Ticket:     key,
+			Symbol:     event.Symbol,
+			Side:       side,
+			Volume:     event.Volume,
+			OpenPrice:  event.Price,
+			OpenTime:   eventTime,
+			StopLoss:   event.StopLoss,
+			TakeProfit: event.TakeProfit,
+			Magic:      event.Magic,
+			Comment:    event.Comment,
+		}
+		state.OpenPositions += 1
+	case models.EventOrderModify:
+		if held {
+			position.StopLoss = event.StopLoss
+			position.TakeProfit = event.TakeProfit
+		}
+	case models.EventPartialClose:
+		if held {
+			position.Volume -= event.Volume
+			if position.Volume <= volumeEpsilon {
+				closePosition(state, key)
+			}
+		}
+	case models.EventOrderClose:
+		if held {
+			closePosition(state, key)
+		}
+	}
+}
+
+func addToPosition(position *models.Position, event *models.MT5Event) {
+	volume := position.Volume + event.Volume
+	if volume > volumeEpsilon {
+		position.OpenPrice = (position.OpenPrice*position.Volume + event.Price*event.Volume) / volume
+	}
+	position.Volume = volume
+	if event.StopLoss != 0 {
+		position.StopLoss = event.StopLoss
+	}
+	if event.TakeProfit != 0 {
+		position.TakeProfit = event.TakeProfit
+	}
+}
+
+func closePosition(state *models.UserState, key int64) {
+	delete(state.Positions, key)
+	if state.OpenPositions > 0 {
+		state.OpenPositions -= 1
+	}
+}
+
+func PositionExposure(positions map[int64]*models.Position) *models.Exposure {
+	exposure := &models.Exposure{BySymbol: []*models.SymbolExposure{}}
+	bySymbol := make(map[string]*models.SymbolExposure)
+
+	for _, position := range positions {
+		symbol, ok := bySymbol[position.Symbol]
+		if !ok {
+			symbol = &models.SymbolExposure{Symbol: position.Symbol}
+			bySymbol[position.Symbol] = symbol
+			exposure.BySymbol = append(exposure.BySymbol, symbol)
+		}
+		symbol.Positions++
+
+		switch position.Side {
+		case models.SideBuy:
+			symbol.Long += position.Volume
+			exposure.Long += position.Volume
+		case models.SideSell:
+			symbol.Short += position.Volume
+			exposure.Short += position.Volume
+		}
+	}
+
+	for _, symbol := range exposure.BySymbol {
+		symbol.Net = symbol.Long - symbol.Short
+		symbol.Gross = symbol.Long + symbol.Short
+	}
+	exposure.Net = exposure.Long - exposure.Short
+	exposure.Gross = exposure.Long + exposure.Short
+
+	sort.Slice(exposure.BySymbol, func(i, j int) bool {
+		return exposure.BySymbol[i].Symbol < exposure.BySymbol[j].Symbol
+	})
+	return exposure
+}
+
+func (s *UserService) GetPositions(userID string) ([]*models.Position, *models.Exposure, bool) {
+	state := s.GetUserState(userID)
+	if state == nil {
+		return nil, nil, false
+	}
+
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
+
+	positions := make([]*models.Position, 0, len(state.Positions))
+	for _, position := range state.Positions {
+		copied := *position
+		positions = append(positions, &copied)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		if positions[i].OpenTime != positions[j].OpenTime {
+			return positions[i].OpenTime < positions[j].OpenTime
+		}
+		return positions[i].Ticket < positions[j].Ticket
+	})
+	return positions, PositionExposure(state.Positions), true
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"math"
+	"testing"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func applyPositions(state *models.UserState, events ...*models.MT5Event) {
+	s := &UserService{}
+	for i, event := range events {
+		s.applyPositionEvent(state, event, int64(1000+i))
+	}
+}
+
+func positionEvent(eventType string, ticket int64, volume, price float64) *models.MT5Event {
+	return &models.MT5Event{EventType: eventType, Ticket: ticket, Symbol: "EURUSD", Side: models.SideBuy, Volume: volume, Price: price}
+}
+
+func TestPositionOpenAndClose(t *testing.T) {
+	state := &models.UserState{}
+	applyPositions(state, positionEvent(models.EventOrderOpen, 1, 2, 1.1))
+
+	position := state.Positions[1]
+	if position == nil || position.Volume != 2 || position.OpenPrice != 1.1 || position.OpenTime != 1000 {
+		t.Fatalf("position = %+v, want 2 lots at 1.1 opened at 1000", position)
+	}
+	if state.OpenPositions != 1 {
+		t.Errorf("OpenPositions = %d, want 1", state.OpenPositions)
+	}
+
+	applyPositions(state, positionEvent(models.EventOrderClose, 1, 0, 0))
+	if len(state.Positions) != 0 || state.OpenPositions != 0 {
+		t.Errorf("after close: %d positions, OpenPositions = %d", len(state.Positions), state.OpenPositions)
+	}
+}
+
+func TestPositionOpenOnHeldTicketAddsVolume(t *testing.T) {
+	state := &models.UserState{}
+	applyPositions(state,
+		positionEvent(models.EventOrderOpen, 1, 1, 1.10),
+		positionEvent(models.EventOrderOpen, 1, 3, 1.20),
+	)
+
+	position := state.Positions[1]
+	if position.Volume != 4 || math.Abs(position.OpenPrice-1.175) > 1e-9 {
+		t.Errorf("position = %v lots at %v, want 4 lots at the weighted 1.175", position.Volume, position.OpenPrice)
+	}
+	if position.OpenTime != 1000 {
+		t.Errorf("OpenTime = %d, want the first deal's 1000", position.OpenTime)
+	}
+	if state.OpenPositions != 1 {
+		t.Errorf("OpenPositions = %d, want 1", state.OpenPositions)
+	}
+}
+
+func TestPositionPartialCloseNeverGoesNegative(t *testing.T) {
+	state := &models.UserState{}
+	applyPositions(state,
+		positionEvent(models.EventOrderOpen, 1, 2, 1.1),
+		positionEvent(models.EventPartialClose, 1, 0.5, 0),
+	)
+	if position := state.Positions[1]; position == nil || position.Volume != 1.5 {
+		t.Fatalf("position = %+v after closing 0.5 of 2 lots", position)
+	}
+
+	applyPositions(state, positionEvent(models.EventPartialClose, 1, 5, 0))
+	if _, held := state.Positions[1]; held || state.OpenPositions != 0 {
+		t.Errorf("closing more than is held left %d positions, OpenPositions = %d", len(state.Positions), state.OpenPositions)
+	}
+}
+
+func TestPositionCloseOfUnknownTicketIsIgnored(t *testing.T) {
+	state := &models.UserState{}
+	applyPositions(state,
+		positionEvent(models.EventOrderOpen, 1, 1, 1.1),
+		positionEvent(models.EventOrderClose, 2, 0, 0),
+		positionEvent(models.EventPartialClose, 3, 1, 0),
+		positionEvent(models.EventOrderClose, 2, 0, 0),
+	)
+	if len(state.Positions) != 1 || state.OpenPositions != 1 {
+		t.Errorf("%d positions, OpenPositions = %d, want the one open position untouched", len(state.Positions), state.OpenPositions)
+	}
+
+	// Without tickets only the count is kept, and it stops at zero.
+	counted := &models.UserState{}
+	applyPositions(counted, positionEvent(models.EventOrderClose, 0, 0, 0))
+	if counted.OpenPositions != 0 {
+		t.Errorf("OpenPositions = %d after closing with none open", counted.OpenPositions)
+	}
+}
@@ -0,0 +1,821 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+// fakeRedis speaks enough RESP for the repository's commands, so service
+// tests run without a Redis server. It is not a general Redis emulator.
+type fakeRedis struct {
+	mu       sync.Mutex
+	strings  map[string]fakeString
+	lists    map[string][]string
+	hashes   map[string]map[string]string
+	streams  map[string]*fakeStream
+	commands map[string]int
+	fail     map[string]error
+	channels map[string]map[*fakeConn]bool
+	closed   bool
+}
+
+type fakeConn struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+func (c *fakeConn) write(reply interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeReply(c.w, reply)
+	return c.w.Flush()
+}
+
+type fakeString struct {
+	value   string
+	expires time.Time
+}
+
+type fakeStream struct {
+	last    streamID
+	entries []fakeEntry
+	groups  map[string]*fakeGroup
+}
+
+type fakeEntry struct {
+	id     streamID
+	fields []string
+}
+
+type fakeGroup struct {
+	delivered streamID
+	pending   map[streamID]*fakePending
+}
+
+type fakePending struct {
+	consumer  string
+	delivered time.Time
+}
+
+type streamID struct{ ms, seq uint64 }
+
+func (id streamID) String() string { return fmt.Sprintf("%d-%d", id.ms, id.seq) }
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || id.ms == other.ms && id.seq < other.seq
+}
+
+func parseStreamID(s string, seqDefault uint64) (streamID, error) {
+	switch s {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamID{^uint64(0), ^uint64(0)}, nil
+	}
+	ms, seq, found := strings.Cut(s, "-")
+	id := streamID{seq: seqDefault}
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return id, fmt.Errorf("ERR Invalid stream ID %q", s)
+	}
+	if found {
+		if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return id, fmt.Errorf("ERR Invalid stream ID %q", s)
+		}
+	}
+	return id, nil
+}
+
+type simpleString string
+
+// newTestRepo starts a fake Redis for the test and returns a repository
+// connected to it.
+func newTestRepo(t *testing.T) (*repository.RedisRepository, *fakeRedis) {
+	t.Helper()
+
+	fake := &fakeRedis{
+		strings:  make(map[string]fakeString),
+		lists:    make(map[string][]string),
+		hashes:   make(map[string]map[string]string),
+		streams:  make(map[string]*fakeStream),
+		commands: make(map[string]int),
+		fail:     make(map[string]error),
+		channels: make(map[string]map[*fakeConn]bool),
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	var conns sync.WaitGroup
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer conns.Done()
+				fake.serve(conn)
+			}()
+		}
+	}()
+
+	repo := repository.NewRedisRepository(listener.Addr().String(), "", 0)
+	t.Cleanup(func() {
+		fake.mu.Lock()
+		fake.closed = true
+		fake.mu.Unlock()
+		repo.Close()
+		listener.Close()
+		conns.Wait()
+	})
+	return repo, fake
+}
+
+// FailCommand makes every later call of the command fail until it is
+// cleared with a nil error.
+func (f *fakeRedis) FailCommand(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err == nil {
+		delete(f.fail, name)
+		return
+	}
+	f.fail[name] = err
+}
+
+func (f *fakeRedis) Count(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.commands[name]
+}
+
+func (f *fakeRedis) Pending(group string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, stream := range f.streams {
+		if g, ok := stream.groups[group]; ok {
+			return len(g.pending)
+		}
+	}
+	return 0
+}
+
+// Age makes every pending entry look idle for at least d.
+func (f *fakeRedis) Age(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, stream := range f.streams {
+		for _, group := range stream.groups {
+			for _, pending := range group.pending {
+				pending.delivered = pending.delivered.Add(-d)
+			}
+		}
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	client := &fakeConn{w: bufio.NewWriter(conn)}
+	defer f.unsubscribe(client)
+	var queued [][]string
+	multi, subscribed := false, false
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		if name == "SUBSCRIBE" {
+			subscribed = true
+			for _, channel := range args[1:] {
+				if err := client.write(f.subscribe(client, channel)); err != nil {
+					return
+				}
+			}
+			continue
+		}
+
+		var reply interface{}
+		switch {
+		case subscribed && name == "PING":
+			reply = []interface{}{"pong", ""}
+		case name == "MULTI":
+			multi, queued, reply = true, nil, simpleString("OK")
+		case name == "EXEC":
+			replies := make([]interface{}, 0, len(queued))
+			for _, cmd := range queued {
+				replies = append(replies, f.run(cmd))
+			}
+			multi, queued, reply = false, nil, replies
+		case multi:
+			queued = append(queued, args)
+			reply = simpleString("QUEUED")
+		default:
+			reply = f.run(args)
+		}
+
+		if err := client.write(reply); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeRedis) subscribe(client *fakeConn, channel string) interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.channels[channel] == nil {
+		f.channels[channel] = make(map[*fakeConn]bool)
+	}
+	f.channels[channel][client] = true
+	return []interface{}{"subscribe", channel, int64(1)}
+}
+
+func (f *fakeRedis) unsubscribe(client *fakeConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, clients := range f.channels {
+		delete(clients, client)
+	}
+}
+
+// Subscribers reports how many connections listen on the channel.
+func (f *fakeRedis) Subscribers(channel string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.channels[channel])
+}
+
+func (f *fakeRedis) publish(args []string) interface{} {
+	var n int64
+	for client := range f.channels[args[0]] {
+		if client.write([]interface{}{"message", args[0], args[1]}) == nil {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) run(args []string) interface{} {
+	name := strings.ToUpper(args[0])
+	if name == "XREADGROUP" {
+		return f.xreadgroup(args[1:])
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands[name]++
+	if err, ok := f.fail[name]; ok {
+		return err
+	}
+
+	handler, ok := fakeCommands[name]
+	if !ok {
+		return fmt.Errorf("ERR unknown command '%s'", args[0])
+	}
+	return handler(f, args[1:])
+}
+
+var fakeCommands map[string]func(*fakeRedis, []string) interface{}
+
+func init() {
+	fakeCommands = map[string]func(*fakeRedis, []string) interface{}{
+		"PING":       func(*fakeRedis, []string) interface{} { return simpleString("PONG") },
+		"GET":        (*fakeRedis).get,
+		"SET":        (*fakeRedis).set,
+		"DEL":        (*fakeRedis).del,
+		"INCR":       (*fakeRedis).incr,
+		"RPUSH":      (*fakeRedis).rpush,
+		"LPUSH":      (*fakeRedis).lpush,
+		"LRANGE":     (*fakeRedis).lrange,
+		"LTRIM":      (*fakeRedis).ltrim,
+		"HSET":       (*fakeRedis).hset,
+		"HGET":       (*fakeRedis).hget,
+		"HGETALL":    (*fakeRedis).hgetall,
+		"HDEL":       (*fakeRedis).hdel,
+		"HLEN":       (*fakeRedis).hlen,
+		"SCAN":       (*fakeRedis).scan,
+		"PUBLISH":    (*fakeRedis).publish,
+		"XADD":       (*fakeRedis).xadd,
+		"XGROUP":     (*fakeRedis).xgroup,
+		"XACK":       (*fakeRedis).xack,
+		"XAUTOCLAIM": (*fakeRedis).xautoclaim,
+		"XRANGE":     (*fakeRedis).xrange,
+	}
+}
+
+func (f *fakeRedis) lookup(key string) (string, bool) {
+	entry, ok := f.strings[key]
+	if !ok {
+		return "", false
+	}
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		delete(f.strings, key)
+		return "", false
+	}
+	return entry.value, true
+}
+
+func (f *fakeRedis) get(args []string) interface{} {
+	value, ok := f.lookup(args[0])
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func (f *fakeRedis) set(args []string) interface{} {
+	key, value := args[0], args[1]
+	entry := fakeString{value: value}
+	nx := false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "NX":
+			nx = true
+		case "EX", "PX":
+			n, _ := strconv.ParseInt(args[i+1], 10, 64)
+			unit := time.Second
+			if strings.ToUpper(args[i]) == "PX" {
+				unit = time.Millisecond
+			}
+			entry.expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		}
+	}
+	if _, exists := f.lookup(key); exists && nx {
+		return nil
+	}
+	f.strings[key] = entry
+	return simpleString("OK")
+}
+
+func (f *fakeRedis) del(args []string) interface{} {
+	var n int64
+	for _, key := range args {
+		if _, ok := f.lookup(key); ok {
+			n++
+		}
+		if _, ok := f.lists[key]; ok {
+			n++
+		}
+		if _, ok := f.hashes[key]; ok {
+			n++
+		}
+		delete(f.strings, key)
+		delete(f.lists, key)
+		delete(f.hashes, key)
+	}
+	return n
+}
+
+func (f *fakeRedis) incr(args []string) interface{} {
+	value, _ := f.lookup(args[0])
+	n, _ := strconv.ParseInt(value, 10, 64)
+	n++
+	f.strings[args[0]] = fakeString{value: strconv.FormatInt(n, 10)}
+	return n
+}
+
+func (f *fakeRedis) rpush(args []string) interface{} {
+	f.lists[args[0]] = append(f.lists[args[0]], args[1:]...)
+	return int64(len(f.lists[args[0]]))
+}
+
+func (f *fakeRedis) lpush(args []string) interface{} {
+	list := f.lists[args[0]]
+	for _, value := range args[1:] {
+		list = append([]string{value}, list...)
+	}
+	f.lists[args[0]] = list
+	return int64(len(list))
+}
+
+func listRange(length int, startArg, stopArg string) (int, int) {
+	start, _ := strconv.Atoi(startArg)
+	stop, _ := strconv.Atoi(stopArg)
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+	return start, stop
+}
+
+func (f *fakeRedis) lrange(args []string) interface{} {
+	list := f.lists[args[0]]
+	start, stop := listRange(len(list), args[1], args[2])
+	items := []interface{}{}
+	for i := start; i <= stop; i++ {
+		items = append(items, list[i])
+	}
+	return items
+}
+
+func (f *fakeRedis) ltrim(args []string) interface{} {
+	list := f.lists[args[0]]
+	start, stop := listRange(len(list), args[1], args[2])
+	if start > stop {
+		delete(f.lists, args[0])
+	} else {
+		f.lists[args[0]] = append([]string(nil), list[start:stop+1]...)
+	}
+	return simpleString("OK")
+}
+
+func (f *fakeRedis) hset(args []string) interface{} {
+	hash, ok := f.hashes[args[0]]
+	if !ok {
+		hash = make(map[string]string)
+		f.hashes[args[0]] = hash
+	}
+	var added int64
+	for i := 1; i+1 < len(args); i += 2 {
+		if _, exists := hash[args[i]]; !exists {
+			added++
+		}
+		hash[args[i]] = args[i+1]
+	}
+	return added
+}
+
+func (f *fakeRedis) hget(args []string) interface{} {
+	value, ok := f.hashes[args[0]][args[1]]
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+func (f *fakeRedis) hgetall(args []string) interface{} {
+	items := []interface{}{}
+	for field, value := range f.hashes[args[0]] {
+		items = append(items, field, value)
+	}
+	return items
+}
+
+func (f *fakeRedis) hdel(args []string) interface{} {
+	var n int64
+	for _, field := range args[1:] {
+		if _, ok := f.hashes[args[0]][field]; ok {
+			delete(f.hashes[args[0]], field)
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) hlen(args []string) interface{} {
+	return int64(len(f.hashes[args[0]]))
+}
+
+func (f *fakeRedis) scan(args []string) interface{} {
+	pattern := "*"
+	for i := 1; i+1 < len(args); i += 2 {
+		if strings.ToUpper(args[i]) == "MATCH" {
+			pattern = args[i+1]
+		}
+	}
+	keys := []interface{}{}
+	for key := range f.strings {
+		if _, ok := f.lookup(key); !ok {
+			continue
+		}
+		if matched, _ := path.Match(pattern, key); matched {
+			keys = append(keys, key)
+		}
+	}
+	return []interface{}{"0", keys}
+}
+
+func (f *fakeRedis) stream(key string, create bool) *fakeStream {
+	stream, ok := f.streams[key]
+	if !ok && create {
+		stream = &fakeStream{groups: make(map[string]*fakeGroup)}
+		f.streams[key] = stream
+	}
+	return stream
+}
+
+func (f *fakeRedis) xadd(args []string) interface{} {
+	stream := f.stream(args[0], true)
+	i := 1
+	maxLen := -1
+	if strings.ToUpper(args[i]) == "MAXLEN" {
+		i++
+		if args[i] == "~" || args[i] == "=" {
+			i++
+		}
+		maxLen, _ = strconv.Atoi(args[i])
+		i++
+	}
+	if args[i] != "*" {
+		return errors.New("ERR the fake only supports auto-generated IDs")
+	}
+	i++
+
+	id := streamID{ms: uint64(time.Now().UnixMilli())}
+	if !stream.last.less(id) {
+		id = streamID{ms: stream.last.ms, seq: stream.last.seq + 1}
+	}
+	stream.last = id
+	stream.entries = append(stream.entries, fakeEntry{id: id, fields: append([]string(nil), args[i:]...)})
+	if maxLen > 0 && len(stream.entries) > maxLen {
+		stream.entries = stream.entries[len(stream.entries)-maxLen:]
+	}
+	return id.String()
+}
+
+func (f *fakeRedis) xgroup(args []string) interface{} {
+	if strings.ToUpper(args[0]) != "CREATE" {
+		return fmt.Errorf("ERR the fake does not support XGROUP %s", args[0])
+	}
+	key, name, start := args[1], args[2], args[3]
+	mkstream := len(args) > 4 && strings.ToUpper(args[4]) == "MKSTREAM"
+	stream := f.stream(key, mkstream)
+	if stream == nil {
+		return errors.New("ERR The XGROUP subcommand requires the key to exist")
+	}
+	if _, exists := stream.groups[name]; exists {
+		return errors.New("BUSYGROUP Consumer Group name already exists")
+	}
+
+	group := &fakeGroup{pending: make(map[streamID]*fakePending)}
+	if start == "$" {
+		group.delivered = stream.last
+	} else {
+		id, err := parseStreamID(start, 0)
+		if err != nil {
+			return err
+		}
+		group.delivered = id
+	}
+	stream.groups[name] = group
+	return simpleString("OK")
+}
+
+func entryReply(entry fakeEntry) interface{} {
+	fields := make([]interface{}, len(entry.fields))
+	for i, field := range entry.fields {
+		fields[i] = field
+	}
+	return []interface{}{entry.id.String(), fields}
+}
+
+func (f *fakeRedis) xreadgroup(args []string) interface{} {
+	var group, consumer, key, start string
+	count := 0
+	block := time.Duration(-1)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "GROUP":
+			group, consumer = args[i+1], args[i+2]
+			i += 2
+		case "COUNT":
+			count, _ = strconv.Atoi(args[i+1])
+			i++
+		case "BLOCK":
+			ms, _ := strconv.Atoi(args[i+1])
+			block = time.Duration(ms) * time.Millisecond
+			i++
+		case "STREAMS":
+			key, start = args[i+1], args[i+2]
+			i = len(args)
+		}
+	}
+
+	deadline := time.Now().Add(block)
+	for {
+		f.mu.Lock()
+		f.commands["XREADGROUP"]++
+		reply, done := f.readGroup(key, group, consumer, start, count)
+		done = done || f.closed
+		f.mu.Unlock()
+		if done || block < 0 || time.Now().After(deadline) {
+			return reply
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func (f *fakeRedis) readGroup(key, groupName, consumer, start string, count int) (interface{}, bool) {
+	if err, ok := f.fail["XREADGROUP"]; ok {
+		return err, true
+	}
+	stream := f.stream(key, false)
+	if stream == nil || stream.groups[groupName] == nil {
+		return errors.New("NOGROUP No such key or consumer group"), true
+	}
+	group := stream.groups[groupName]
+	messages := []interface{}{}
+
+	if start == ">" {
+		for _, entry := range stream.entries {
+			if count > 0 && len(messages) == count {
+				break
+			}
+			if !group.delivered.less(entry.id) {
+				continue
+			}
+			group.delivered = entry.id
+			group.pending[entry.id] = &fakePending{consumer: consumer, delivered: time.Now()}
+			messages = append(messages, entryReply(entry))
+		}
+		if len(messages) == 0 {
+			return nil, false
+		}
+		return []interface{}{[]interface{}{key, messages}}, true
+	}
+
+	after, err := parseStreamID(start, 0)
+	if err != nil {
+		return err, true
+	}
+	for _, entry := range stream.entries {
+		if count > 0 && len(messages) == count {
+			break
+		}
+		pending, ok := group.pending[entry.id]
+		if !ok || pending.consumer != consumer || !after.less(entry.id) {
+			continue
+		}
+		messages = append(messages, entryReply(entry))
+	}
+	return []interface{}{[]interface{}{key, messages}}, true
+}
+
+func (f *fakeRedis) xack(args []string) interface{} {
+	stream := f.stream(args[0], false)
+	if stream == nil || stream.groups[args[1]] == nil {
+		return int64(0)
+	}
+	group := stream.groups[args[1]]
+	var n int64
+	for _, arg := range args[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			return err
+		}
+		if _, ok := group.pending[id]; ok {
+			delete(group.pending, id)
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) xautoclaim(args []string) interface{} {
+	stream := f.stream(args[0], false)
+	if stream == nil || stream.groups[args[1]] == nil {
+		return errors.New("NOGROUP No such key or consumer group")
+	}
+	group := stream.groups[args[1]]
+	consumer := args[2]
+	idleMs, _ := strconv.ParseInt(args[3], 10, 64)
+	start, err := parseStreamID(args[4], 0)
+	if err != nil {
+		return err
+	}
+	count := 100
+	if len(args) > 6 && strings.ToUpper(args[5]) == "COUNT" {
+		count, _ = strconv.Atoi(args[6])
+	}
+
+	ids := make([]streamID, 0, len(group.pending))
+	for id := range group.pending {
+		if !id.less(start) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+
+	messages := []interface{}{}
+	next := "0-0"
+	minIdle := time.Duration(idleMs) * time.Millisecond
+	for i, id := range ids {
+		if len(messages) == count {
+			next = ids[i].String()
+			break
+		}
+		pending := group.pending[id]
+		if time.Since(pending.delivered) < minIdle {
+			continue
+		}
+		pending.consumer = consumer
+		pending.delivered = time.Now()
+		for _, entry := range stream.entries {
+			if entry.id == id {
+				messages = append(messages, entryReply(entry))
+			}
+		}
+	}
+	return []interface{}{next, messages, []interface{}{}}
+}
+
+func (f *fakeRedis) xrange(args []string) interface{} {
+	stream := f.stream(args[0], false)
+	messages := []interface{}{}
+	if stream == nil {
+		return messages
+	}
+	start, err := parseStreamID(args[1], 0)
+	if err != nil {
+		return err
+	}
+	end, err := parseStreamID(args[2], ^uint64(0))
+	if err != nil {
+		return err
+	}
+	count := 0
+	if len(args) > 4 && strings.ToUpper(args[3]) == "COUNT" {
+		count, _ = strconv.Atoi(args[4])
+	}
+	for _, entry := range stream.entries {
+		if count > 0 && len(messages) == count {
+			break
+		}
+		if entry.id.less(start) || end.less(entry.id) {
+			continue
+		}
+		messages = append(messages, entryReply(entry))
+	}
+	return messages
+}
+
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[0] != '*' {
+		return nil, fmt.Errorf("unexpected request line %q", line)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(header[1:]))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args[i] = string(data[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case simpleString:
+		fmt.Fprintf(w, "+%s\r\n", v)
+	case error:
+		fmt.Fprintf(w, "-%s\r\n", v.Error())
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case []interface{}:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("fake redis cannot encode %T", reply))
+	}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+const BundleVersion = 1
+
+func (s *RuleService) ExportBundle() (*models.RuleBundle, error) {
+	rules, err := s.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	// Derived rules keep their template_id, so the templates travel with
+	// them and a later template update still re-renders the imported rules.
+	templates, err := s.repo.GetAllTemplates()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].CreatedAt < templates[j].CreatedAt })
+	return &models.RuleBundle{
+		Version:    BundleVersion,
+		ExportedAt: time.Now().Unix(),
+		Templates:  templates,
+		Rules:      rules,
+	}, nil
+}
+
+func EncodeBundle(bundle *models.RuleBundle, format string) ([]byte, error) {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil || format != "yaml" {
+		return data, err
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func DecodeBundle(data []byte, format string) (*models.RuleBundle, error) {
+	if format == "yaml" {
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%w: invalid YAML bundle: %v", ErrInvalidRule, err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid YAML bundle: %v", ErrInvalidRule, err)
+		}
+		data = converted
+	}
+
+	var bundle models.RuleBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("%w: invalid bundle: %v", ErrInvalidRule, err)
+	}
+	return &bundle, nil
+}
+
+func (s *RuleService) ImportBundle(bundle *models.RuleBundle, dryRun bool, author string) (*models.BundlePlan, error) {
+	if err := validateBundle(bundle); err != nil {
+		return nil, err
+	}
+
+	current, err := s.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]*models.Rule, len(current))
+	for _, rule := range current {
+		normalized := *rule
+		normalizeMode(&normalized)
+		existing[rule.ID] = &normalized
+	}
+
+	templates, err := s.planTemplates(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &models.BundlePlan{
+		Templates: templates,
+		Create:    []string{},
+		Update:    []*models.BundleUpdate{},
+		Delete:    []string{},
+	}
+	incoming := make(map[string]bool, len(bundle.Rules))
+	for _, rule := range bundle.Rules {
+		incoming[rule.ID] = true
+		old, exists := existing[rule.ID]
+		if !exists {
+			plan.Create = append(plan.Create, rule.ID)
+			continue
+		}
+
+		changes, err := diffRules(old, rule, "created_at", "updated_at")
+		if err != nil {
+			return nil, err
+		}
+		if len(changes) > 0 {
+			plan.Update = append(plan.Update, &models.BundleUpdate{ID: rule.ID, Changes: changes})
+		}
+		rule.CreatedAt = old.CreatedAt
+	}
+	for id := range existing {
+		if !incoming[id] {
+			plan.Delete = append(plan.Delete, id)
+		}
+	}
+	sort.Strings(plan.Create)
+	sort.Strings(plan.Delete)
+
+	if dryRun {
+		return plan, nil
+	}
+
+	if err := s.applyBundle(bundle, plan, existing, author); err != nil {
+		return nil, err
+	}
+	plan.Applied = true
+	return plan, nil
+}
+
+// planTemplates lists the bundle's templates that are new or differ from the
+// stored ones, and checks that every derived rule's template is either in
+// the bundle or already stored. Stored templates the bundle omits are kept.
+func (s *RuleService) planTemplates(bundle *models.RuleBundle) ([]string, error) {
+	current, err := s.repo.GetAllTemplates()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]*models.RuleTemplate, len(current)+len(bundle.Templates))
+	for _, template := range current {
+		known[template.ID] = template
+	}
+
+	changed := []string{}
+	for _, template := range bundle.Templates {
+		old, exists := known[template.ID]
+		if exists {
+			template.CreatedAt = old.CreatedAt
+			template.UpdatedAt = old.UpdatedAt
+			before, err := json.Marshal(old)
+			if err != nil {
+				return nil, err
+			}
+			after, err := json.Marshal(template)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(before, after) {
+				continue
+			}
+		}
+		changed = append(changed, template.ID)
+		known[template.ID] = template
+	}
+
+	verr := &ValidationError{}
+	for i, rule := range bundle.Rules {
+		if rule.TemplateID != "" && known[rule.TemplateID] == nil {
+			verr.add(fmt.Sprintf("rules[%d].template_id", i), "unknown template %q", rule.TemplateID)
+		}
+	}
+	return changed, verr.orNil()
+}
+
+func (s *RuleService) applyBundle(bundle *models.RuleBundle, plan *models.BundlePlan, existing map[string]*models.Rule, author string) error {
+	templates := make(map[string]bool, len(plan.Templates))
+	for _, id := range plan.Templates {
+		templates[id] = true
+	}
+	now := time.Now().UnixNano()
+	for _, template := range bundle.Templates {
+		if !templates[template.ID] {
+			continue
+		}
+		if template.CreatedAt == 0 {
+			template.CreatedAt = now
+		}
+		template.UpdatedAt = now
+		if err := s.repo.SaveTemplate(template); err != nil {
+			return err
+		}
+	}
+
+	changed := make(map[string]bool)
+	for _, id := range plan.Create {
+		changed[id] = true
+	}
+	for _, update := range plan.Update {
+		changed[update.ID] = true
+	}
+
+	for _, rule := range bundle.Rules {
+		if !changed[rule.ID] {
+			continue
+		}
+
+		operation := models.RevisionUpdate
+		if _, exists := existing[rule.ID]; !exists {
+			operation = models.RevisionCreate
+			rule.CreatedAt = now
+		}
+		rule.UpdatedAt = now
+
+		if err := s.repo.SaveRule(rule); err != nil {
+			return err
+		}
+		s.recordRevision(operation, author, rule, 0)
+	}
+
+	for _, id := range plan.Delete {
+		if err := s.repo.DeleteRule(id); err != nil {
+			return err
+		}
+		s.recordRevision(models.RevisionDelete, author, existing[id], 0)
+	}
+
+	if len(changed) > 0 || len(plan.Delete) > 0 || len(plan.Templates) > 0 {
+		log.Printf("Rule bundle applied by %s: %d created, %d updated, %d deleted, %d templates saved", author, len(plan.Create), len(plan.Update), len(plan.Delete), len(plan.Templates))
+		s.rulesChanged("bundle")
+	}
+	return nil
+}
+
+func validateBundle(bundle *models.RuleBundle) error {
+	verr := &ValidationError{}
+	if bundle.Version != BundleVersion {
+		verr.add("version", "unsupported bundle version %d, expected %d", bundle.Version, BundleVersion)
+	}
+
+	seenTemplates := make(map[string]bool, len(bundle.Templates))
+	for i, template := range bundle.Templates {
+		path := fmt.Sprintf("templates[%d]", i)
+		if template == nil {
+			verr.add(path, "must not be empty")
+			continue
+		}
+		if template.ID == "" {
+			verr.add(path+".id", "is required")
+		} else if seenTemplates[template.ID] {
+			verr.add(path+".id", "duplicate template id %q", template.ID)
+		}
+		seenTemplates[template.ID] = true
+
+		if err := validateTemplate(template); err != nil {
+			prefixProblems(verr, path, err)
+		}
+	}
+
+	seen := make(map[string]bool, len(bundle.Rules))
+	for i, rule := range bundle.Rules {
+		path := fmt.Sprintf("rules[%d]", i)
+		if rule == nil {
+			verr.add(path, "must not be empty")
+			continue
+		}
+		if rule.ID == "" {
+			verr.add(path+".id", "is required")
+		} else if seen[rule.ID] {
+			verr.add(path+".id", "duplicate rule id %q", rule.ID)
+		}
+		seen[rule.ID] = true
+
+		normalizeMode(rule)
+		if err := ValidateRule(rule); err != nil {
+			for _, problem := range err.(*ValidationError).Problems {
+				verr.add(path+"."+problem.Field, "%s", problem.Message)
+			}
+		}
+	}
+	return verr.orNil()
+}
+
+func normalizeMode(rule *models.Rule) {
+	rule.Mode = rule.EffectiveMode()
+	rule.Enabled = rule.Mode != models.RuleModeDisabled
+}
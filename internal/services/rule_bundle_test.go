@@ -0,0 +1,95 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func symbolTemplate(expression string) *dto.TemplateRequest {
+	return &dto.TemplateRequest{
+		Name:       "symbol volume cap",
+		Params:     []models.TemplateParam{{Name: "symbol", Type: models.ParamString}},
+		Expression: expression,
+		Actions:    []models.Action{{Type: models.ActionWarn, Severity: 1}},
+	}
+}
+
+func TestBundleCarriesTemplatesOfDerivedRules(t *testing.T) {
+	source := newTestEngine(t, 0, 1, 8, 1)
+	templates := NewTemplateService(source.repo, source.rules)
+	template, err := templates.CreateTemplate(symbolTemplate("event.symbol == ${symbol} and event.volume > 5"))
+	if err != nil {
+		t.Fatalf("CreateTemplate: %v", err)
+	}
+	rule, err := templates.Instantiate(template.ID, &dto.InstantiateTemplateRequest{Name: "gold cap", Params: map[string]interface{}{"symbol": "XAUUSD"}, Enabled: true}, "test")
+	if err != nil {
+		t.Fatalf("Instantiate: %v", err)
+	}
+
+	bundle, err := source.rules.ExportBundle()
+	if err != nil {
+		t.Fatalf("ExportBundle: %v", err)
+	}
+	data, err := EncodeBundle(bundle, "yaml")
+	if err != nil {
+		t.Fatalf("EncodeBundle: %v", err)
+	}
+
+	target := newTestEngine(t, 0, 1, 8, 1)
+	decoded, err := DecodeBundle(data, "yaml")
+	if err != nil {
+		t.Fatalf("DecodeBundle: %v", err)
+	}
+	plan, err := target.rules.ImportBundle(decoded, false, "test")
+	if err != nil {
+		t.Fatalf("ImportBundle: %v", err)
+	}
+	if len(plan.Templates) != 1 || plan.Templates[0] != template.ID {
+		t.Errorf("plan.Templates = %v, want [%s]", plan.Templates, template.ID)
+	}
+
+	again, err := target.rules.ImportBundle(decoded, true, "test")
+	if err != nil {
+		t.Fatalf("ImportBundle dry run: %v", err)
+	}
+	if len(again.Templates) != 0 {
+		t.Errorf("re-importing plans template changes %v, want none", again.Templates)
+	}
+
+	// The imported rule still follows its template.
+	targetTemplates := NewTemplateService(target.repo, target.rules)
+	if _, err := targetTemplates.UpdateTemplate(template.ID, symbolTemplate("event.symbol == ${symbol} and event.volume > 9"), "test"); err != nil {
+		t.Fatalf("UpdateTemplate on the importing side: %v", err)
+	}
+	updated, err := target.rules.getRule(rule.ID)
+	if err != nil {
+		t.Fatalf("getRule: %v", err)
+	}
+	if want := `event.symbol == "XAUUSD" and event.volume > 9`; updated.Expression != want {
+		t.Errorf("Expression = %q, want %q", updated.Expression, want)
+	}
+}
+
+func TestBundleRejectsRulesOfUnknownTemplates(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	bundle := &models.RuleBundle{
+		Version: BundleVersion,
+		Rules: []*models.Rule{{
+			ID:         "orphan",
+			Name:       "orphan",
+			Expression: "event.volume > 1",
+			Actions:    []models.Action{{Type: models.ActionWarn, Severity: 1}},
+			Enabled:    true,
+			TemplateID: "template-missing",
+		}},
+	}
+
+	_, err := e.rules.ImportBundle(bundle, true, "test")
+	var verr *ValidationError
+	if !errors.As(err, &verr) || len(verr.Problems) != 1 || verr.Problems[0].Field != "rules[0].template_id" {
+		t.Errorf("ImportBundle error = %v, want a problem with rules[0].template_id", err)
+	}
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+type CompiledRule struct {
+	*models.Rule
+	Expr conditions.Expr
+}
+
+type ruleSnapshot struct {
+	rules []*CompiledRule
+}
+
+type ruleCache struct {
+	snapshot atomic.Pointer[ruleSnapshot]
+	done     chan struct{}
+}
+
+func newRuleCache() *ruleCache {
+	c := &ruleCache{done: make(chan struct{})}
+	c.snapshot.Store(&ruleSnapshot{})
+	return c
+}
+
+func compileRules(rules []*models.Rule) []*CompiledRule {
+	compiled := make([]*CompiledRule, 0, len(rules))
+	for _, rule := range rules {
+		expr, err := compileRule(rule)
+		if err != nil {
+			log.Printf("Skipping rule %s: %v", rule.ID, err)
+			continue
+		}
+		compiled = append(compiled, &CompiledRule{Rule: rule, Expr: expr})
+	}
+	return compiled
+}
+
+func (s *RuleService) Rules() []*CompiledRule {
+	return s.cache.snapshot.Load().rules
+}
+
+func (s *RuleService) Reload() error {
+	rules, err := s.GetAllRules()
+	if err != nil {
+		return err
+	}
+
+	s.cache.snapshot.Store(&ruleSnapshot{rules: compileRules(rules)})
+	return nil
+}
+
+func (s *RuleService) rulesChanged(id string) {
+	if err := s.Reload(); err != nil {
+		log.Printf("Failed to reload rules after change to %s: %v", id, err)
+	}
+	if err := s.repo.PublishRuleChange(id); err != nil {
+		log.Printf("Failed to publish rule change for %s: %v", id, err)
+	}
+}
+
+func (s *RuleService) StartSync(refreshInterval time.Duration) {
+	pubsub := s.repo.SubscribeRuleChanges()
+	ticker := time.NewTicker(refreshInterval)
+
+	go func() {
+		defer pubsub.Close()
+		defer ticker.Stop()
+
+		messages := pubsub.Channel()
+		for {
+			select {
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				if err := s.Reload(); err != nil {
+					log.Printf("Failed to reload rules after change to %s: %v", msg.Payload, err)
+				}
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Printf("Periodic rule reload failed: %v", err)
+				}
+			case <-s.cache.done:
+				return
+			}
+		}
+	}()
+}
+
+func (s *RuleService) StopSync() {
+	close(s.cache.done)
+}
@@ -0,0 +1,377 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/pkg/evaluators"
+)
+
+var (
+	ErrInvalidRule  = errors.New("invalid rule")
+	ErrRuleNotFound = errors.New("rule not found")
+)
+
+type ruleField struct {
+	kind conditions.Kind
+	get  func(event *models.MT5Event, state *models.UserState) conditions.Value
+}
+
+var ruleFields = map[string]ruleField{
+	"event.user_id": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.String(e.UserId)
+	}},
+	"event.event_type": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.String(e.EventType)
+	}},
+	"event.symbol": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.String(e.Symbol)
+	}},
+	"event.volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.Volume)
+	}},
+	"event.price": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.Price)
+	}},
+	"event.timestamp": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(e.Timestamp))
+	}},
+	"event.ticket": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(e.Ticket))
+	}},
+	"event.position_id": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(e.PositionID))
+	}},
+	"event.side": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return optionalString(e.Side)
+	}},
+	"event.order_type": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return optionalString(e.OrderType)
+	}},
+	"event.sl": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.StopLoss)
+	}},
+	"event.tp": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.TakeProfit)
+	}},
+	"event.magic": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(e.Magic))
+	}},
+	"event.comment": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return optionalString(e.Comment)
+	}},
+	"event.amount": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.Amount)
+	}},
+	"event.margin_level": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(e.MarginLevel)
+	}},
+	"state.balance": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.Balance)
+	}},
+	"state.equity": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.Equity)
+	}},
+	"state.open_positions": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.OpenPositions))
+	}},
+	"state.day_volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.DayVolume)
+	}},
+	"state.last_activity": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.LastActivity))
+	}},
+	"state.risk_level": {conditions.KindString, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return optionalString(s.RiskLevel)
+	}},
+	"state.long_volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(PositionExposure(s.Positions).Long)
+	}},
+	"state.short_volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(PositionExposure(s.Positions).Short)
+	}},
+	"state.net_volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(PositionExposure(s.Positions).Net)
+	}},
+	"state.gross_volume": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(PositionExposure(s.Positions).Gross)
+	}},
+	"state.pending_orders": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.PendingOrders))
+	}},
+	"state.margin_level": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.MarginLevel)
+	}},
+	"state.margin_calls": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.MarginCalls))
+	}},
+	"state.stop_outs": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.StopOuts))
+	}},
+	"state.logged_in": {conditions.KindBool, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Bool(s.LoggedIn)
+	}},
+	"state.violation_count": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(float64(s.ViolationCount))
+	}},
+	"state.initial_balance": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.InitialBalance)
+	}},
+	"state.day_start_balance": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.DayStartBalance)
+	}},
+	"state.day_start_equity": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.DayStartEquity)
+	}},
+	"state.equity_high_water": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return conditions.Number(s.EquityHighWater)
+	}},
+	"state.daily_loss": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.DayStartBalance, s, false)
+	}},
+	"state.daily_loss_pct": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.DayStartBalance, s, true)
+	}},
+	"state.daily_equity_loss": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.DayStartEquity, s, false)
+	}},
+	"state.daily_equity_loss_pct": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.DayStartEquity, s, true)
+	}},
+	"state.drawdown": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.InitialBalance, s, false)
+	}},
+	"state.drawdown_pct": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.InitialBalance, s, true)
+	}},
+	"state.trailing_drawdown": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.EquityHighWater, s, false)
+	}},
+	"state.trailing_drawdown_pct": {conditions.KindNumber, func(e *models.MT5Event, s *models.UserState) conditions.Value {
+		return lossFrom(s.EquityHighWater, s, true)
+	}},
+}
+
+var lossLimitKeys = map[string]string{
+	"max_daily_loss":            "state.daily_loss",
+	"max_daily_loss_pct":        "state.daily_loss_pct",
+	"max_daily_equity_loss":     "state.daily_equity_loss",
+	"max_daily_equity_loss_pct": "state.daily_equity_loss_pct",
+	"max_drawdown":              "state.drawdown",
+	"max_drawdown_pct":          "state.drawdown_pct",
+	"max_trailing_drawdown":     "state.trailing_drawdown",
+	"max_trailing_drawdown_pct": "state.trailing_drawdown_pct",
+}
+
+func optionalString(value string) conditions.Value {
+	if value == "" {
+		return conditions.Null()
+	}
+	return conditions.String(value)
+}
+
+func lossFrom(reference float64, s *models.UserState, percent bool) conditions.Value {
+	if reference <= 0 || s.EquityHighWater <= 0 {
+		return conditions.Null()
+	}
+
+	loss := reference - s.Equity
+	if percent {
+		return conditions.Number(loss / reference * 100)
+	}
+	return conditions.Number(loss)
+}
+
+var windowFunc = conditions.Func{
+	Params:  []conditions.Kind{conditions.KindString, conditions.KindNumber, conditions.KindString},
+	MinArgs: 2,
+	Result:  conditions.KindNumber,
+}
+
+const (
+	customDataPrefix = "custom_data."
+	exposurePrefix   = "exposure."
+)
+
+var timeFields = map[string]conditions.Kind{
+	"time.hour":                   conditions.KindNumber,
+	"time.minute":                 conditions.KindNumber,
+	"time.minute_of_day":          conditions.KindNumber,
+	"time.weekday":                conditions.KindString,
+	"time.seconds_since_rollover": conditions.KindNumber,
+	"time.seconds_to_rollover":    conditions.KindNumber,
+}
+
+var ruleSchema = func() *conditions.Schema {
+	schema := &conditions.Schema{
+		Fields: make(map[string]conditions.Kind, len(ruleFields)+len(timeFields)),
+		Prefixes: map[string]conditions.Kind{
+			customDataPrefix: conditions.KindString,
+			exposurePrefix:   conditions.KindNumber,
+		},
+		Funcs: map[string]conditions.Func{
+			"window_count": windowFunc,
+			"window_sum":   windowFunc,
+			"window_avg":   windowFunc,
+			"time_between": {
+				Params:  []conditions.Kind{conditions.KindString, conditions.KindString},
+				MinArgs: 2,
+				Result:  conditions.KindBool,
+			},
+			"number": {
+				Params:  []conditions.Kind{conditions.KindString},
+				MinArgs: 1,
+				Result:  conditions.KindNumber,
+			},
+			"defined": {
+				Params:  []conditions.Kind{conditions.KindString},
+				MinArgs: 1,
+				Result:  conditions.KindBool,
+			},
+		},
+	}
+	for name, field := range ruleFields {
+		schema.Fields[name] = field.kind
+	}
+	for name, kind := range timeFields {
+		schema.Fields[name] = kind
+	}
+	return schema
+}()
+
+type ruleEnv struct {
+	event   *models.MT5Event
+	state   *models.UserState
+	windows *WindowService
+	clock   *BrokerClock
+	input   *evaluators.Input
+}
+
+func (e *ruleEnv) now() int64 {
+	if e.event.Timestamp != 0 {
+		return e.event.Timestamp
+	}
+	return time.Now().Unix()
+}
+
+func (e *ruleEnv) Lookup(name string) (conditions.Value, bool) {
+	if _, ok := timeFields[name]; ok {
+		return e.timeField(name), true
+	}
+	if key, ok := strings.CutPrefix(name, customDataPrefix); ok {
+		value, ok := e.state.CustomData[key]
+		if !ok {
+			return conditions.Null(), true
+		}
+		return conditions.String(value), true
+	}
+	if symbol, ok := strings.CutPrefix(name, exposurePrefix); ok {
+		for _, exposure := range PositionExposure(e.state.Positions).BySymbol {
+			if exposure.Symbol == symbol {
+				return conditions.Number(exposure.Net), true
+			}
+		}
+		return conditions.Number(0), true
+	}
+
+	field, ok := ruleFields[name]
+	if !ok {
+		return conditions.Null(), false
+	}
+	return field.get(e.event, e.state), true
+}
+
+func (e *ruleEnv) timeField(name string) conditions.Value {
+	now := e.now()
+	t := e.clock.Time(now)
+
+	switch name {
+	case "time.hour":
+		return conditions.Number(float64(t.Hour()))
+	case "time.minute":
+		return conditions.Number(float64(t.Minute()))
+	case "time.minute_of_day":
+		return conditions.Number(float64(t.Hour()*60 + t.Minute()))
+	case "time.weekday":
+		return conditions.String(t.Weekday().String()[:3])
+	case "time.seconds_since_rollover":
+		return conditions.Number(float64(now - e.clock.DayStart(now)))
+	case "time.seconds_to_rollover":
+		return conditions.Number(float64(e.clock.NextDayStart(now) - now))
+	}
+	return conditions.Null()
+}
+
+func (e *ruleEnv) Call(name string, args []conditions.Value) (conditions.Value, error) {
+	switch name {
+	case "window_count", "window_sum", "window_avg":
+		seconds := int64(args[1].Num)
+		if seconds <= 0 || seconds > e.windows.Retention() {
+			return conditions.Null(), fmt.Errorf("%s window must be between 1 and %d seconds", name, e.windows.Retention())
+		}
+		symbol := ""
+		if len(args) > 2 {
+			symbol = args[2].Str
+		}
+
+		agg := e.windows.Aggregate(e.event.UserId, args[0].Str, symbol, seconds, e.now())
+		switch name {
+		case "window_count":
+			return conditions.Number(float64(agg.Count)), nil
+		case "window_sum":
+			return conditions.Number(agg.Sum), nil
+		}
+		return conditions.Number(agg.Avg()), nil
+	case "time_between":
+		start, err := parseClock(args[0].Str)
+		if err != nil {
+			return conditions.Null(), err
+		}
+		end, err := parseClock(args[1].Str)
+		if err != nil {
+			return conditions.Null(), err
+		}
+
+		t := e.clock.Time(e.now())
+		minute := t.Hour()*60 + t.Minute()
+		if start <= end {
+			return conditions.Bool(minute >= start && minute < end), nil
+		}
+		return conditions.Bool(minute >= start || minute < end), nil
+	case "number":
+		if args[0].Kind == conditions.KindNull {
+			return conditions.Null(), nil
+		}
+		n, err := strconv.ParseFloat(strings.TrimSpace(args[0].Str), 64)
+		if err != nil {
+			return conditions.Null(), nil
+		}
+		return conditions.Number(n), nil
+	case "defined":
+		return conditions.Bool(args[0].Kind != conditions.KindNull), nil
+	}
+	return conditions.Null(), fmt.Errorf("unknown function %q", name)
+}
+
+func CompileExpression(src string) (conditions.Expr, error) {
+	expr, err := conditions.Parse(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	if expr, err = bindEvaluators(expr); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	if err := conditions.Check(expr, ruleSchema); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+	}
+	return expr, nil
+}
+
+func formatNumber(n float64) string {
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
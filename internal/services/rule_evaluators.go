@@ -0,0 +1,250 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/pkg/evaluators"
+)
+
+func init() {
+	for key, field := range lossLimitKeys {
+		evaluators.Register(key, evaluators.NumberLimit(field, fieldNumber(field)))
+	}
+}
+
+func fieldNumber(name string) func(in *evaluators.Input) (float64, bool) {
+	return func(in *evaluators.Input) (float64, bool) {
+		v, ok := in.Field(name)
+		n, isNumber := v.(float64)
+		return n, ok && isNumber
+	}
+}
+
+func (e *ruleEnv) evaluatorInput() *evaluators.Input {
+	if e.input != nil {
+		return e.input
+	}
+
+	ev, st := e.event, e.state
+	positions := make([]evaluators.Position, 0, len(st.Positions))
+	for _, p := range st.Positions {
+		positions = append(positions, evaluators.Position{
+			Ticket:     p.Ticket,
+			Symbol:     p.Symbol,
+			Side:       p.Side,
+			Volume:     p.Volume,
+			OpenPrice:  p.OpenPrice,
+			OpenTime:   p.OpenTime,
+			StopLoss:   p.StopLoss,
+			TakeProfit: p.TakeProfit,
+		})
+	}
+
+	e.input = &evaluators.Input{
+		Event: &evaluators.Event{
+			ID:          ev.EventID,
+			UserID:      ev.UserId,
+			Type:        ev.EventType,
+			Symbol:      ev.Symbol,
+			Volume:      ev.Volume,
+			Price:       ev.Price,
+			Timestamp:   ev.Timestamp,
+			Ticket:      ev.Ticket,
+			PositionID:  ev.PositionID,
+			Side:        ev.Side,
+			OrderType:   ev.OrderType,
+			StopLoss:    ev.StopLoss,
+			TakeProfit:  ev.TakeProfit,
+			Magic:       ev.Magic,
+			Comment:     ev.Comment,
+			Amount:      ev.Amount,
+			MarginLevel: ev.MarginLevel,
+		},
+		State: &evaluators.State{
+			UserID:          st.UserID,
+			Balance:         st.Balance,
+			Equity:          st.Equity,
+			OpenPositions:   st.OpenPositions,
+			PendingOrders:   st.PendingOrders,
+			DayVolume:       st.DayVolume,
+			RiskLevel:       st.RiskLevel,
+			ViolationCount:  st.ViolationCount,
+			InitialBalance:  st.InitialBalance,
+			DayStartBalance: st.DayStartBalance,
+			DayStartEquity:  st.DayStartEquity,
+			EquityHighWater: st.EquityHighWater,
+			MarginLevel:     st.MarginLevel,
+			LoggedIn:        st.LoggedIn,
+			CustomData:      st.CustomData,
+			Positions:       positions,
+		},
+		Now: e.now(),
+		Field: func(name string) (interface{}, bool) {
+			v, ok := e.Lookup(name)
+			if !ok || v.Kind == conditions.KindNull {
+				return nil, false
+			}
+			return v.Interface(), true
+		},
+	}
+	return e.input
+}
+
+type evaluatorCondition struct {
+	name  string
+	param string
+	cond  evaluators.Condition
+}
+
+func (c *evaluatorCondition) evaluate(env conditions.Env) (evaluators.Result, error) {
+	re, ok := env.(*ruleEnv)
+	if !ok {
+		return evaluators.Result{}, fmt.Errorf("condition %s needs a rule environment", c.name)
+	}
+	return c.cond.Evaluate(re.evaluatorInput()), nil
+}
+
+func (c *evaluatorCondition) Eval(env conditions.Env) (conditions.Value, error) {
+	result, err := c.evaluate(env)
+	if err != nil {
+		return conditions.Null(), err
+	}
+	return conditions.Bool(result.Matched), nil
+}
+
+func (c *evaluatorCondition) Explain(env conditions.Env) (conditions.Clause, bool, error) {
+	result, err := c.evaluate(env)
+	if err != nil || !result.Matched {
+		return conditions.Clause{}, false, err
+	}
+	return conditions.Clause{
+		Condition: c.String(),
+		Subject:   result.Subject,
+		Operator:  result.Operator,
+		Observed:  toValue(result.Observed),
+		Threshold: toValue(result.Threshold),
+	}, true, nil
+}
+
+func (c *evaluatorCondition) ResultKind() conditions.Kind {
+	return conditions.KindBool
+}
+
+func (c *evaluatorCondition) String() string {
+	return c.name + "(" + conditions.Quote(c.param) + ")"
+}
+
+func toValue(v interface{}) conditions.Value {
+	switch x := v.(type) {
+	case float64:
+		return conditions.Number(x)
+	case int:
+		return conditions.Number(float64(x))
+	case int64:
+		return conditions.Number(float64(x))
+	case string:
+		return conditions.String(x)
+	case bool:
+		return conditions.Bool(x)
+	}
+	return conditions.Null()
+}
+
+func compileConditions(conds map[string]string) (conditions.Expr, error) {
+	keys := make([]string, 0, len(conds))
+	for key := range conds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var expr conditions.Expr
+	for _, key := range keys {
+		evaluator, ok := evaluators.Lookup(key)
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown condition %q", ErrInvalidRule, key)
+		}
+		node, err := newEvaluatorCondition(evaluator, key, conds[key])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRule, err)
+		}
+		if expr == nil {
+			expr = node
+		} else {
+			expr = &conditions.Logical{Op: "OR", Left: expr, Right: node}
+		}
+	}
+
+	if expr == nil {
+		return &conditions.Literal{Value: conditions.Bool(false)}, nil
+	}
+	return expr, nil
+}
+
+func newEvaluatorCondition(evaluator evaluators.Evaluator, name, param string) (*evaluatorCondition, error) {
+	cond, err := evaluator.Parse(param)
+	if err != nil {
+		return nil, fmt.Errorf("condition %s %v", name, err)
+	}
+	return &evaluatorCondition{name: name, param: param, cond: cond}, nil
+}
+
+// bindEvaluators turns calls such as max_volume("5") in an expression into
+// the registered evaluator, so custom detectors work in expressions too.
+func bindEvaluators(e conditions.Expr) (conditions.Expr, error) {
+	var err error
+	switch n := e.(type) {
+	case *conditions.Call:
+		if _, builtin := ruleSchema.Funcs[n.Name]; !builtin {
+			if evaluator, ok := evaluators.Lookup(n.Name); ok {
+				var param *conditions.Literal
+				if len(n.Args) == 1 {
+					param, _ = n.Args[0].(*conditions.Literal)
+				}
+				if param == nil || param.Value.Kind != conditions.KindString {
+					return nil, fmt.Errorf("%s expects a single string argument", n.Name)
+				}
+				return newEvaluatorCondition(evaluator, n.Name, param.Value.Str)
+			}
+		}
+		for i := range n.Args {
+			if n.Args[i], err = bindEvaluators(n.Args[i]); err != nil {
+				return nil, err
+			}
+		}
+	case *conditions.Not:
+		n.X, err = bindEvaluators(n.X)
+	case *conditions.Neg:
+		n.X, err = bindEvaluators(n.X)
+	case *conditions.Logical:
+		if n.Left, err = bindEvaluators(n.Left); err == nil {
+			n.Right, err = bindEvaluators(n.Right)
+		}
+	case *conditions.Compare:
+		if n.Left, err = bindEvaluators(n.Left); err == nil {
+			n.Right, err = bindEvaluators(n.Right)
+		}
+	case *conditions.In:
+		if n.X, err = bindEvaluators(n.X); err != nil {
+			return nil, err
+		}
+		for i := range n.Set {
+			if n.Set[i], err = bindEvaluators(n.Set[i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func compileRule(rule *models.Rule) (conditions.Expr, error) {
+	if rule.Expression != "" {
+		return CompileExpression(rule.Expression)
+	}
+	return compileConditions(rule.Conditions)
+}
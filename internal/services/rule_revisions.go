@@ -0,0 +1,130 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func (s *RuleService) recordRevision(operation, author string, rule *models.Rule, source int64) {
+	if author == "" {
+		author = "unknown"
+	}
+
+	revision := &models.RuleRevision{
+		RuleID:         rule.ID,
+		Operation:      operation,
+		Author:         author,
+		Timestamp:      time.Now().Unix(),
+		SourceRevision: source,
+		Rule:           rule,
+	}
+	if err := s.repo.AppendRuleRevision(revision); err != nil {
+		log.Printf("Failed to record %s revision for rule %s: %v", operation, rule.ID, err)
+	}
+}
+
+func (s *RuleService) GetRevisions(id string) ([]*models.RuleRevision, error) {
+	return s.repo.GetRuleRevisions(id)
+}
+
+func (s *RuleService) getRevision(id string, number int64) (*models.RuleRevision, error) {
+	revisions, err := s.repo.GetRuleRevisions(id)
+	if err != nil {
+		return nil, err
+	}
+	for _, revision := range revisions {
+		if revision.Revision == number {
+			return revision, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: revision %d of rule %s", ErrRuleNotFound, number, id)
+}
+
+func (s *RuleService) DiffRevisions(id string, from, to int64) ([]*models.RuleFieldDiff, error) {
+	older, err := s.getRevision(id, from)
+	if err != nil {
+		return nil, err
+	}
+	newer, err := s.getRevision(id, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffRules(older.Rule, newer.Rule, "updated_at")
+}
+
+func diffRules(older, newer *models.Rule, ignore ...string) ([]*models.RuleFieldDiff, error) {
+	before, err := flattenRule(older)
+	if err != nil {
+		return nil, err
+	}
+	after, err := flattenRule(newer)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]bool)
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	for _, name := range ignore {
+		delete(names, name)
+	}
+
+	diffs := []*models.RuleFieldDiff{}
+	for name := range names {
+		if !reflect.DeepEqual(before[name], after[name]) {
+			diffs = append(diffs, &models.RuleFieldDiff{Field: name, From: before[name], To: after[name]})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Field < diffs[j].Field })
+	return diffs, nil
+}
+
+func (s *RuleService) Rollback(id string, number int64, author string) (*models.Rule, error) {
+	revision, err := s.getRevision(id, number)
+	if err != nil {
+		return nil, err
+	}
+	if revision.Operation == models.RevisionDelete {
+		return nil, fmt.Errorf("%w: revision %d is a deletion", ErrInvalidRule, number)
+	}
+
+	rule := *revision.Rule
+	rule.UpdatedAt = time.Now().UnixNano()
+
+	// A revision was valid when it was recorded, but fields, functions and
+	// validation rules may have changed since.
+	normalizeMode(&rule)
+	if err := ValidateRule(&rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SaveRule(&rule); err != nil {
+		return nil, err
+	}
+	s.recordRevision(models.RevisionRollback, author, &rule, number)
+	s.rulesChanged(rule.ID)
+	return &rule, nil
+}
+
+func flattenRule(rule *models.Rule) (map[string]interface{}, error) {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestRollbackValidatesTheRevision(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	rule, err := e.rules.CreateRule(&dto.CreateRuleRequest{
+		Name:       "big trades",
+		Expression: "event.volume > 1",
+		Enabled:    true,
+		Actions:    []models.Action{{Type: models.ActionWarn, Severity: 1}},
+	}, "test")
+	if err != nil {
+		t.Fatalf("CreateRule: %v", err)
+	}
+
+	// Recorded before a field it uses was removed.
+	stale := *rule
+	stale.Expression = "event.retired_field > 1"
+	e.rules.recordRevision(models.RevisionUpdate, "test", &stale, 0)
+
+	revisions, err := e.rules.GetRevisions(rule.ID)
+	if err != nil || len(revisions) != 2 {
+		t.Fatalf("GetRevisions = %d, %v, want 2", len(revisions), err)
+	}
+	_, err = e.rules.Rollback(rule.ID, revisions[1].Revision, "test")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("Rollback error = %v, want a validation error", err)
+	}
+
+	current, err := e.rules.getRule(rule.ID)
+	if err != nil || current.Expression != rule.Expression {
+		t.Errorf("rule after a failed rollback = %+v, %v, want it unchanged", current, err)
+	}
+	if _, err := e.rules.Rollback(rule.ID, revisions[0].Revision, "test"); err != nil {
+		t.Errorf("Rollback to the valid revision: %v", err)
+	}
+}
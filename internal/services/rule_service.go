@@ -1,112 +1,318 @@
 package services
 
 import (
+	"errors"
+	"fmt"
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
 	"github.com/NOTMKW/DLLBEL/internal/dto"
 	"github.com/NOTMKW/DLLBEL/internal/models"
 	"github.com/NOTMKW/DLLBEL/internal/repository"
-	"fmt"
-	"strconv"
+	"log"
+	"sort"
+	"strings"
 	"time"
 )
 
 type RuleService struct {
-	repo *repository.RedisRepository
+	repo    *repository.RedisRepository
+	windows *WindowService
+	clock   *BrokerClock
+	cache   *ruleCache
 }
 
-func NewRuleService(repo *repository.RedisRepository) *RuleService {
-	return &RuleService{repo: repo}
+func NewRuleService(repo *repository.RedisRepository, windows *WindowService, clock *BrokerClock) *RuleService {
+	return &RuleService{repo: repo, windows: windows, clock: clock, cache: newRuleCache()}
 }
 
-func (s *RuleService) CreateRule(req *dto.CreateRuleRequest) (*models.Rule, error) {
-	rule := &models.Rule{
-		ID:         fmt.Sprintf("rule-%d", time.Now().UnixNano()),
-		Name:       req.Name,
-		Conditions: req.Conditions,
-		Actions:    req.Actions,
-		Enabled:    req.Enabled,
-		Priority:   req.Priority,
-		CreatedAt:  time.Now().UnixNano(),
-		UpdatedAt:  time.Now().UnixNano(),
+func (s *RuleService) CreateRule(req *dto.CreateRuleRequest, author string) (*models.Rule, error) {
+	rule, err := s.BuildRule(req)
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.repo.SaveRule(rule); err != nil {
 		return nil, err
 	}
+	s.recordRevision(models.RevisionCreate, author, rule, 0)
+	s.rulesChanged(rule.ID)
 	return rule, nil
 }
 
-func (s *RuleService) UpdateRule(id string, req *dto.UpdateRuleRequest) (*models.Rule, error) {
-	rule, err := s.repo.GetRule(id)
+func (s *RuleService) BuildRule(req *dto.CreateRuleRequest) (*models.Rule, error) {
+	mode := req.Mode
+	if mode == "" {
+		mode = models.RuleModeDisabled
+		if req.Enabled {
+			mode = models.RuleModeEnforce
+		}
+	}
+
+	rule := &models.Rule{
+		ID:                fmt.Sprintf("rule-%d", time.Now().UnixNano()),
+		Name:              req.Name,
+		Conditions:        req.Conditions,
+		Expression:        req.Expression,
+		Actions:           req.Actions,
+		Enabled:           mode != models.RuleModeDisabled,
+		Mode:              mode,
+		Priority:          req.Priority,
+		Final:             req.Final,
+		SingleAction:      req.SingleAction,
+		CooldownSeconds:   req.CooldownSeconds,
+		CooldownPerSymbol: req.CooldownPerSymbol,
+		Escalation:        req.Escalation,
+		ViolationDecay:    req.ViolationDecay,
+		Trigger:           req.Trigger,
+		CreatedAt:         time.Now().UnixNano(),
+		UpdatedAt:         time.Now().UnixNano(),
+	}
+
+	if err := ValidateRule(rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *RuleService) UpdateRule(id string, req *dto.UpdateRuleRequest, author string) (*models.Rule, error) {
+	rule, err := s.getRule(id)
 	if err != nil {
 		return nil, err
 	}
 
+	if req.Conditions != nil && req.Expression != "" {
+		verr := &ValidationError{}
+		verr.add("expression", "use either conditions or expression, not both")
+		return nil, verr
+	}
+
+	if req.TemplateParams != nil {
+		if rule.TemplateID == "" {
+			verr := &ValidationError{}
+			verr.add("template_params", "rule is not derived from a template")
+			return nil, verr
+		}
+		template, err := s.getTemplate(rule.TemplateID)
+		if err != nil {
+			return nil, err
+		}
+		params := declaredParams(template, rule.TemplateParams)
+		for name, value := range req.TemplateParams {
+			params[name] = value
+		}
+		if err := applyTemplate(rule, template, params); err != nil {
+			return nil, err
+		}
+	}
+	if req.Conditions != nil || req.Expression != "" || req.Actions != nil || req.Escalation != nil {
+		rule.TemplateID = ""
+		rule.TemplateParams = nil
+	}
+
 	if req.Name != "" {
 		rule.Name = req.Name
 	}
 	if req.Conditions != nil {
 		rule.Conditions = req.Conditions
+		rule.Expression = ""
+	}
+	if req.Expression != "" {
+		rule.Expression = req.Expression
+		rule.Conditions = nil
 	}
 	if req.Actions != nil {
 		rule.Actions = req.Actions
 	}
+	mode := rule.EffectiveMode()
 	if req.Enabled != nil {
-		rule.Enabled = *req.Enabled
+		if !*req.Enabled {
+			mode = models.RuleModeDisabled
+		} else if mode == models.RuleModeDisabled {
+			mode = models.RuleModeEnforce
+		}
 	}
+	if req.Mode != nil {
+		mode = *req.Mode
+	}
+	rule.Mode = mode
+	rule.Enabled = mode != models.RuleModeDisabled
 	if req.Priority != nil {
 		rule.Priority = *req.Priority
 	}
+	if req.Final != nil {
+		rule.Final = *req.Final
+	}
+	if req.SingleAction != nil {
+		rule.SingleAction = *req.SingleAction
+	}
+	if req.CooldownSeconds != nil {
+		rule.CooldownSeconds = *req.CooldownSeconds
+	}
+	if req.CooldownPerSymbol != nil {
+		rule.CooldownPerSymbol = *req.CooldownPerSymbol
+	}
+	if req.Escalation != nil {
+		rule.Escalation = req.Escalation
+	}
+	if req.ViolationDecay != nil {
+		rule.ViolationDecay = *req.ViolationDecay
+	}
+	if req.Trigger != nil {
+		rule.Trigger = *req.Trigger
+	}
 	rule.UpdatedAt = time.Now().UnixNano()
 
+	if err := ValidateRule(rule); err != nil {
+		return nil, err
+	}
+
 	if err := s.repo.SaveRule(rule); err != nil {
 		return nil, err
 	}
+	s.recordRevision(models.RevisionUpdate, author, rule, 0)
+	s.rulesChanged(rule.ID)
 	return rule, nil
 }
 
-func (s *RuleService) DeleteRule(id string) error {
-	return s.repo.DeleteRule(id)
+func (s *RuleService) DeleteRule(id string, author string) error {
+	rule, err := s.getRule(id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.DeleteRule(id); err != nil {
+		return err
+	}
+	s.recordRevision(models.RevisionDelete, author, rule, 0)
+	s.rulesChanged(id)
+	return nil
+}
+
+func (s *RuleService) getRule(id string) (*models.Rule, error) {
+	rule, err := s.repo.GetRule(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrRuleNotFound, id)
+	}
+	return rule, err
 }
 
 func (s *RuleService) GetAllRules() ([]*models.Rule, error) {
-	return s.repo.GetAllRules()
-}
-
-func (s *RuleService) EvaluateRule (rule *models.Rule, event *models.MT5Event, state *models.UserState) bool {
-	for field, value := range rule.Conditions {
-		switch field {
-		case "max_volume":
-			if maxVol, err := strconv.ParseFloat(value, 64); err == nil {
-				if event.Volume > maxVol {
-					return true
-				}
-			}
-			break
-		case "max_positions":
-			if maxPos, err := strconv.Atoi(value); err == nil {
-				if state.OpenPositions > maxPos {
-					return true
-				}
-			}
-			break
-		case "max_day_volume":
-			if maxDayVol, err := strconv.ParseFloat(value, 64); err == nil {
-				if state.DayVolume > maxDayVol {
-					return true
-				}
-			}
-			break
-		case "symbol_restricted":
-			if event.Symbol == value {
-				return true
-			}
-			break
+	rules, err := s.repo.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+	SortRules(rules)
+	return rules, nil
+}
+
+func SortRules(rules []*models.Rule) {
+	sort.SliceStable(rules, func(i, j int) bool {
+		if rules[i].Priority != rules[j].Priority {
+			return rules[i].Priority > rules[j].Priority
+		}
+		if rules[i].CreatedAt != rules[j].CreatedAt {
+			return rules[i].CreatedAt < rules[j].CreatedAt
+		}
+		return rules[i].ID < rules[j].ID
+	})
+}
+
+func (s *RuleService) ActionsFor(rule *models.Rule) []models.Action {
+	if !rule.SingleAction || len(rule.Actions) <= 1 {
+		return rule.Actions
+	}
+
+	strongest := rule.Actions[0]
+	for _, action := range rule.Actions[1:] {
+		if action.Severity > strongest.Severity {
+			strongest = action
 		}
 	}
+	return []models.Action{strongest}
+}
 
-	return false
+func (s *RuleService) ActionsForStep(rule *models.Rule, step int) []models.Action {
+	if len(rule.Escalation) == 0 || step < 1 {
+		return s.ActionsFor(rule)
+	}
+	if step > len(rule.Escalation) {
+		step = len(rule.Escalation)
+	}
+	return []models.Action{rule.Escalation[step-1]}
 }
 
-func generateID() string {
-	return fmt.Sprintf("%d", time.Now().UnixNano())
-}
\ No newline at end of file
+func (s *RuleService) EvaluateRule(rule *models.Rule, event *models.MT5Event, state *models.UserState) bool {
+	expr, err := compileRule(rule)
+	if err != nil {
+		log.Printf("Rule %s has an invalid condition: %v", rule.ID, err)
+		return false
+	}
+	return s.Evaluate(&CompiledRule{Rule: rule, Expr: expr}, event, state)
+}
+
+func (s *RuleService) Evaluate(rule *CompiledRule, event *models.MT5Event, state *models.UserState) bool {
+	return s.evaluateWith(rule, event, state, s.windows)
+}
+
+func (s *RuleService) evaluateWith(rule *CompiledRule, event *models.MT5Event, state *models.UserState, windows *WindowService) bool {
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
+
+	result, err := rule.Expr.Eval(&ruleEnv{event: event, state: state, windows: windows, clock: s.clock})
+	if err != nil {
+		log.Printf("Failed to evaluate rule %s: %v", rule.ID, err)
+		return false
+	}
+
+	return result.Kind == conditions.KindBool && result.Bool
+}
+
+func (s *RuleService) Explain(rule *CompiledRule, event *models.MT5Event, state *models.UserState) *models.RuleExplanation {
+	explanation := &models.RuleExplanation{
+		RuleID:     rule.ID,
+		RuleName:   rule.Name,
+		Expression: rule.Expr.String(),
+		EventType:  event.EventType,
+		Symbol:     event.Symbol,
+		Matched:    []models.MatchedCondition{},
+	}
+
+	state.Mu.RLock()
+	clauses, err := conditions.Explain(rule.Expr, &ruleEnv{event: event, state: state, windows: s.windows, clock: s.clock})
+	state.Mu.RUnlock()
+	if err != nil {
+		log.Printf("Failed to explain rule %s: %v", rule.ID, err)
+		return explanation
+	}
+
+	for _, clause := range clauses {
+		matched := models.MatchedCondition{
+			Condition: clause.Condition,
+			Subject:   clause.Subject,
+			Operator:  clause.Operator,
+			Observed:  clause.Observed.Interface(),
+			Threshold: clause.Threshold.Interface(),
+			Summary:   clause.Condition,
+		}
+		switch {
+		case clause.Operator != "" && clause.Threshold.Kind != conditions.KindNull:
+			matched.Summary = fmt.Sprintf("%s was %s (%s %s)", clause.Subject, clause.Observed, clause.Operator, clause.Threshold)
+		case clause.Operator != "":
+			matched.Summary = fmt.Sprintf("%s was %s (%s)", clause.Subject, clause.Observed, clause.Condition)
+		}
+		explanation.Matched = append(explanation.Matched, matched)
+	}
+	return explanation
+}
+
+func ExplanationReason(explanation *models.RuleExplanation) string {
+	reason := "Rule violation: " + explanation.RuleName
+	if len(explanation.Matched) == 0 {
+		return reason
+	}
+
+	summaries := make([]string, len(explanation.Matched))
+	for i, matched := range explanation.Matched {
+		summaries[i] = matched.Summary
+	}
+	return reason + " - " + strings.Join(summaries, "; ")
+}
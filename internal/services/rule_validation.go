@@ -0,0 +1,159 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/pkg/evaluators"
+)
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationError struct {
+	Problems []FieldError `json:"problems"`
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		msgs[i] = p.Field + ": " + p.Message
+	}
+	return "invalid rule: " + strings.Join(msgs, "; ")
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRule
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Problems = append(e.Problems, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+func (e *ValidationError) orNil() error {
+	if len(e.Problems) == 0 {
+		return nil
+	}
+	return e
+}
+
+var actionTypes = map[string]bool{
+	models.ActionWarn:           true,
+	models.ActionBlockOrder:     true,
+	models.ActionClosePositions: true,
+	models.ActionDisableTrading: true,
+}
+
+func ValidateRule(rule *models.Rule) error {
+	verr := &ValidationError{}
+
+	if strings.TrimSpace(rule.Name) == "" {
+		verr.add("name", "is required")
+	}
+
+	switch rule.Mode {
+	case models.RuleModeEnforce, models.RuleModeShadow, models.RuleModeDisabled:
+	default:
+		verr.add("mode", "must be one of %s, %s or %s", models.RuleModeEnforce, models.RuleModeShadow, models.RuleModeDisabled)
+	}
+
+	switch {
+	case rule.Expression != "" && len(rule.Conditions) > 0:
+		verr.add("expression", "use either conditions or expression, not both")
+	case rule.Expression != "":
+		validateExpression(verr, rule.Expression)
+	case len(rule.Conditions) == 0:
+		verr.add("conditions", "conditions or expression required")
+	default:
+		validateLegacyConditions(verr, rule.Conditions)
+	}
+
+	switch rule.Trigger {
+	case "", models.TriggerEvent, models.TriggerSchedule:
+	default:
+		verr.add("trigger", "must be %s or %s", models.TriggerEvent, models.TriggerSchedule)
+	}
+
+	if rule.CooldownSeconds < 0 {
+		verr.add("cooldown_seconds", "must not be negative")
+	}
+
+	if rule.ViolationDecay < 0 {
+		verr.add("violation_decay_seconds", "must not be negative")
+	}
+
+	if len(rule.Actions) == 0 && len(rule.Escalation) == 0 {
+		verr.add("actions", "at least one action is required")
+	}
+	validateActions(verr, "actions", rule.Actions)
+	validateActions(verr, "escalation", rule.Escalation)
+
+	return verr.orNil()
+}
+
+func validateActions(verr *ValidationError, path string, actions []models.Action) {
+	for i, action := range actions {
+		if !actionTypes[action.Type] {
+			verr.add(fmt.Sprintf("%s[%d].type", path, i), "unknown action type %q", action.Type)
+		}
+		if action.Severity < 1 || action.Severity > 5 {
+			verr.add(fmt.Sprintf("%s[%d].severity", path, i), "must be between 1 and 5")
+		}
+	}
+}
+
+func validateExpression(verr *ValidationError, src string) {
+	expr, err := conditions.Parse(src)
+	if err != nil {
+		verr.add("expression", "%v", err)
+		return
+	}
+	if expr, err = bindEvaluators(expr); err != nil {
+		verr.add("expression", "%v", err)
+		return
+	}
+	if err := conditions.Check(expr, ruleSchema); err != nil {
+		verr.add("expression", "%v", err)
+		return
+	}
+
+	conditions.Walk(expr, func(n conditions.Expr) {
+		call, ok := n.(*conditions.Call)
+		if !ok || call.Name != "time_between" {
+			return
+		}
+		for _, arg := range call.Args {
+			if lit, ok := arg.(*conditions.Literal); ok {
+				if _, err := parseClock(lit.Value.Str); err != nil {
+					verr.add("expression", "%v", err)
+				}
+			}
+		}
+	})
+}
+
+func validateLegacyConditions(verr *ValidationError, conds map[string]string) {
+	keys := make([]string, 0, len(conds))
+	for key := range conds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := "conditions." + key
+
+		evaluator, ok := evaluators.Lookup(key)
+		if !ok {
+			verr.add(path, "unknown condition")
+			continue
+		}
+		if _, err := evaluator.Parse(conds[key]); err != nil {
+			verr.add(path, "%v", err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+type ShadowService struct {
+	repo      *repository.RedisRepository
+	wsService *WebSocketService
+}
+
+func NewShadowService(repo *repository.RedisRepository, wsService *WebSocketService) *ShadowService {
+	return &ShadowService{
+		repo:      repo,
+		wsService: wsService,
+	}
+}
+
+func (s *ShadowService) Record(rule *models.Rule, event *models.MT5Event, enforcement *models.EnforcementMessage) {
+	record := &models.ShadowEnforcement{
+		RuleID:      rule.ID,
+		RuleName:    rule.Name,
+		EventType:   event.EventType,
+		Symbol:      event.Symbol,
+		Enforcement: *enforcement,
+		RecordedAt:  time.Now().Unix(),
+	}
+
+	if err := s.repo.SaveShadowEnforcement(record); err != nil {
+		log.Printf("Failed to store shadow enforcement for rule %s: %v", rule.ID, err)
+	}
+	s.wsService.SendShadowEnforcement(record)
+	log.Printf("Shadow rule '%s' would have triggered '%s' for user %s", rule.Name, enforcement.Action, enforcement.UserId)
+}
+
+func (s *ShadowService) List(query *dto.ShadowQuery) ([]*models.ShadowEnforcement, error) {
+	records, err := s.repo.GetShadowEnforcements()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+
+	result := make([]*models.ShadowEnforcement, 0, limit)
+	for _, record := range records {
+		if query.RuleID != "" && record.RuleID != query.RuleID {
+			continue
+		}
+		if query.UserID != "" && record.Enforcement.UserId != query.UserID {
+			continue
+		}
+		result = append(result, record)
+		if len(result) == limit {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+var errShuttingDown = errors.New("engine is shutting down")
+
+type DrainReport struct {
+	Drained  int64
+	Retained int
+	Lost     int
+	TimedOut bool
+}
+
+func (s *EventService) Drain(ctx context.Context) *DrainReport {
+	start := s.processed.Load()
+	report := &DrainReport{}
+
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+	for len(s.inflight) > 0 && !report.TimedOut {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			report.TimedOut = true
+		}
+	}
+
+	s.Stop()
+	stopped := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		report.TimedOut = true
+	}
+	report.Drained = s.processed.Load() - start
+
+	// Events that made it into the event log stay unacknowledged and are
+	// resumed on the next start; anything queued without a log entry is gone.
+	unprocessed := len(s.inflight)
+	for _, partition := range s.partitions {
+		for pending := true; pending; {
+			select {
+			case item := <-partition:
+				if !item.start() {
+					continue
+				}
+				if item.replay != nil {
+					item.replay.pending.Done()
+				}
+				if item.id == "" && !item.scheduled {
+					report.Lost++
+				}
+			default:
+				pending = false
+			}
+		}
+	}
+	if report.Lost > unprocessed {
+		unprocessed = report.Lost
+	}
+	report.Retained = unprocessed - report.Lost
+	return report
+}
+
+func (s *DLLService) isClosing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closing
+}
+
+func (s *DLLService) StopAccepting() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closing = true
+	for _, listener := range s.listeners {
+		listener.Close()
+	}
+	s.listeners = nil
+
+	for _, conn := range s.connections {
+		conn.Conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (s *DLLService) Goodbye(ctx context.Context) (notified, failed int) {
+	s.mu.Lock()
+	conns := make([]*models.DLLConnection, 0, len(s.connections))
+	for _, conn := range s.connections {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	frame := &models.EnforcementMessage{
+		Action:    models.ActionGoodbye,
+		Reason:    "engine is shutting down",
+		Timestamp: time.Now().Unix(),
+	}
+	pending := make(map[*models.DLLConnection]chan struct{}, len(conns))
+	for _, conn := range conns {
+		s.mu.RLock()
+		written, ok := s.writers[conn]
+		s.mu.RUnlock()
+		if !ok {
+			failed++
+			continue
+		}
+
+		select {
+		case conn.EnforceChan <- frame:
+			pending[conn] = written
+		case <-ctx.Done():
+			failed++
+			log.Printf("Could not send goodbye to DLL %s before the shutdown deadline", conn.ID)
+		}
+	}
+
+	for conn, written := range pending {
+		select {
+		case <-written:
+			notified++
+		case <-ctx.Done():
+			failed++
+			log.Printf("Timed out writing goodbye to DLL %s", conn.ID)
+		}
+	}
+
+	s.mu.Lock()
+	for id, conn := range s.connections {
+		conn.Conn.Close()
+		delete(s.connections, id)
+	}
+	s.mu.Unlock()
+	close(s.relayDone)
+	return notified, failed
+}
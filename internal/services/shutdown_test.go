@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestDrainProcessesQueuedEventsBeforeFlush(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	e.events.Start()
+	user := userFor(e.history, true)
+	for seq := uint64(1); seq <= 5; seq++ {
+		if !e.events.TrySubmit("dll-1", testOpen(user, seq)) {
+			t.Fatalf("TrySubmit %d refused", seq)
+		}
+	}
+
+	// Long enough for the consumer's blocking read of the event log to return.
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	report := e.events.Drain(ctx)
+	if report.TimedOut || report.Retained != 0 || report.Lost != 0 {
+		t.Errorf("Drain = %+v, want every event processed in time", report)
+	}
+	if volume := dayVolume(e.users, user); volume != 5 {
+		t.Errorf("DayVolume = %v after draining, want 5", volume)
+	}
+	if pending := e.fake.Pending(e.history.group); pending != 0 {
+		t.Errorf("%d entries left unacknowledged", pending)
+	}
+
+	if saved, failed := e.users.Flush(ctx); saved != 1 || failed != 0 {
+		t.Errorf("Flush = %d saved, %d failed, want 1 and 0", saved, failed)
+	}
+	stored, err := e.repo.GetUserState(user)
+	if err != nil || stored.DayVolume != 5 {
+		t.Errorf("stored state = %+v, %v, want the drained volume", stored, err)
+	}
+}
+
+func TestDrainCountsRetainedAndLostEvents(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	user := userFor(e.history, true)
+
+	// Logged, so the next start resumes it.
+	e.events.TrySubmit("dll-1", testOpen(user, 1))
+	// Queued without a log entry, so it is gone once the engine stops.
+	e.fake.FailCommand("XADD", fmt.Errorf("ERR event log unavailable"))
+	e.events.TrySubmit("dll-1", testOpen(user, 2))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	report := e.events.Drain(ctx)
+	if !report.TimedOut || report.Retained != 1 || report.Lost != 1 {
+		t.Errorf("Drain = %+v, want a timeout with 1 retained and 1 lost", report)
+	}
+}
+
+func TestGoodbyeReachesTerminalsAfterIntakeStops(t *testing.T) {
+	repo, _ := newTestRepo(t)
+	s := NewDLLService(&acceptAll{events: make(chan *models.MT5Event, 10)}, repo, BackpressureBlock, time.Second, NewDeadLetterService(repo))
+	terminal, dllConn := connect(t, s, "dll-1")
+	written := make(chan struct{})
+	s.mu.Lock()
+	s.writers[dllConn] = written
+	s.mu.Unlock()
+	go s.enforceWriter(dllConn, written)
+
+	s.StopAccepting()
+	if err := s.StartListener("dll-2"); !errors.Is(err, errShuttingDown) {
+		t.Errorf("StartListener after StopAccepting = %v, want errShuttingDown", err)
+	}
+
+	received := make(chan *models.EnforcementMessage, 1)
+	go func() {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(terminal, header); err != nil {
+			return
+		}
+		data := make([]byte, binary.LittleEndian.Uint32(header))
+		if _, err := io.ReadFull(terminal, data); err != nil {
+			return
+		}
+		var message models.EnforcementMessage
+		if message.Deserialize(data) == nil {
+			received <- &message
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if notified, failed := s.Goodbye(ctx); notified != 1 || failed != 0 {
+		t.Errorf("Goodbye = %d notified, %d failed, want 1 and 0", notified, failed)
+	}
+	select {
+	case message := <-received:
+		if message.Action != models.ActionGoodbye {
+			t.Errorf("terminal received %q, want %q", message.Action, models.ActionGoodbye)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("terminal never received the goodbye frame")
+	}
+}
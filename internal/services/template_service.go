@@ -0,0 +1,367 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/conditions"
+	"github.com/NOTMKW/DLLBEL/internal/dto"
+	"github.com/NOTMKW/DLLBEL/internal/models"
+	"github.com/NOTMKW/DLLBEL/internal/repository"
+)
+
+var (
+	placeholderPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	paramNamePattern   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+type TemplateService struct {
+	repo        *repository.RedisRepository
+	ruleService *RuleService
+}
+
+func NewTemplateService(repo *repository.RedisRepository, ruleService *RuleService) *TemplateService {
+	return &TemplateService{
+		repo:        repo,
+		ruleService: ruleService,
+	}
+}
+
+func (s *TemplateService) GetAllTemplates() ([]*models.RuleTemplate, error) {
+	templates, err := s.repo.GetAllTemplates()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].CreatedAt < templates[j].CreatedAt })
+	return templates, nil
+}
+
+func (s *TemplateService) GetTemplate(id string) (*models.RuleTemplate, error) {
+	return s.ruleService.getTemplate(id)
+}
+
+func (s *TemplateService) CreateTemplate(req *dto.TemplateRequest) (*models.RuleTemplate, error) {
+	template := templateFromRequest(req)
+	template.ID = fmt.Sprintf("template-%d", time.Now().UnixNano())
+	template.CreatedAt = time.Now().UnixNano()
+	template.UpdatedAt = template.CreatedAt
+
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+	if err := s.repo.SaveTemplate(template); err != nil {
+		return nil, err
+	}
+	return template, nil
+}
+
+func (s *TemplateService) UpdateTemplate(id string, req *dto.TemplateRequest, author string) (*models.RuleTemplate, error) {
+	existing, err := s.ruleService.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	template := templateFromRequest(req)
+	template.ID = existing.ID
+	template.CreatedAt = existing.CreatedAt
+	template.UpdatedAt = time.Now().UnixNano()
+
+	if err := validateTemplate(template); err != nil {
+		return nil, err
+	}
+
+	derived, err := s.derivedRules(id)
+	if err != nil {
+		return nil, err
+	}
+
+	verr := &ValidationError{}
+	for _, rule := range derived {
+		if err := applyTemplate(rule, template, declaredParams(template, rule.TemplateParams)); err != nil {
+			prefixProblems(verr, "rules["+rule.ID+"]", err)
+			continue
+		}
+		if err := ValidateRule(rule); err != nil {
+			prefixProblems(verr, "rules["+rule.ID+"]", err)
+		}
+	}
+	if err := verr.orNil(); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SaveTemplate(template); err != nil {
+		return nil, err
+	}
+	for _, rule := range derived {
+		rule.UpdatedAt = template.UpdatedAt
+		if err := s.repo.SaveRule(rule); err != nil {
+			return nil, err
+		}
+		s.ruleService.recordRevision(models.RevisionUpdate, author, rule, 0)
+	}
+	if len(derived) > 0 {
+		s.ruleService.rulesChanged(id)
+	}
+	return template, nil
+}
+
+func (s *TemplateService) DeleteTemplate(id string) error {
+	if _, err := s.ruleService.getTemplate(id); err != nil {
+		return err
+	}
+
+	derived, err := s.derivedRules(id)
+	if err != nil {
+		return err
+	}
+	if len(derived) > 0 {
+		return fmt.Errorf("%w: template %s is used by %d rules", ErrInvalidRule, id, len(derived))
+	}
+	return s.repo.DeleteTemplate(id)
+}
+
+func (s *TemplateService) Instantiate(id string, req *dto.InstantiateTemplateRequest, author string) (*models.Rule, error) {
+	template, err := s.ruleService.getTemplate(id)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := req.Mode
+	if mode == "" {
+		mode = models.RuleModeDisabled
+		if req.Enabled {
+			mode = models.RuleModeEnforce
+		}
+	}
+
+	rule := &models.Rule{
+		ID:        fmt.Sprintf("rule-%d", time.Now().UnixNano()),
+		Name:      req.Name,
+		Enabled:   mode != models.RuleModeDisabled,
+		Mode:      mode,
+		CreatedAt: time.Now().UnixNano(),
+		UpdatedAt: time.Now().UnixNano(),
+	}
+	if err := applyTemplate(rule, template, req.Params); err != nil {
+		return nil, err
+	}
+	if err := ValidateRule(rule); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SaveRule(rule); err != nil {
+		return nil, err
+	}
+	s.ruleService.recordRevision(models.RevisionCreate, author, rule, 0)
+	s.ruleService.rulesChanged(rule.ID)
+	return rule, nil
+}
+
+func (s *TemplateService) derivedRules(id string) ([]*models.Rule, error) {
+	rules, err := s.ruleService.GetAllRules()
+	if err != nil {
+		return nil, err
+	}
+
+	derived := []*models.Rule{}
+	for _, rule := range rules {
+		if rule.TemplateID == id {
+			derived = append(derived, rule)
+		}
+	}
+	return derived, nil
+}
+
+func (s *RuleService) getTemplate(id string) (*models.RuleTemplate, error) {
+	template, err := s.repo.GetTemplate(id)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, fmt.Errorf("%w: template %s", ErrRuleNotFound, id)
+	}
+	return template, err
+}
+
+func applyTemplate(rule *models.Rule, template *models.RuleTemplate, params map[string]interface{}) error {
+	expression, supplied, err := renderTemplate(template, params)
+	if err != nil {
+		return err
+	}
+
+	rule.TemplateID = template.ID
+	rule.TemplateParams = supplied
+	rule.Expression = expression
+	rule.Conditions = nil
+	rule.Actions = template.Actions
+	rule.Escalation = template.Escalation
+	rule.Priority = template.Priority
+	rule.Final = template.Final
+	rule.SingleAction = template.SingleAction
+	rule.CooldownSeconds = template.CooldownSeconds
+	rule.CooldownPerSymbol = template.CooldownPerSymbol
+	rule.ViolationDecay = template.ViolationDecay
+	rule.Trigger = template.Trigger
+	return nil
+}
+
+// renderTemplate fills in defaults for missing parameters but only returns
+// the supplied ones, so a rule picks up later changes to a default.
+func renderTemplate(template *models.RuleTemplate, params map[string]interface{}) (string, map[string]interface{}, error) {
+	verr := &ValidationError{}
+	declared := make(map[string]bool, len(template.Params))
+	supplied := make(map[string]interface{}, len(params))
+	literals := make(map[string]string, len(template.Params))
+
+	for _, param := range template.Params {
+		declared[param.Name] = true
+		path := "template_params." + param.Name
+
+		value, ok := params[param.Name]
+		if ok && value != nil {
+			supplied[param.Name] = value
+		} else {
+			value = param.Default
+		}
+		if value == nil {
+			verr.add(path, "is required")
+			continue
+		}
+
+		literal, err := paramLiteral(param.Type, value)
+		if err != nil {
+			verr.add(path, "%v", err)
+			continue
+		}
+		literals[param.Name] = literal
+	}
+
+	for name := range params {
+		if !declared[name] {
+			verr.add("template_params."+name, "unknown parameter")
+		}
+	}
+	if err := verr.orNil(); err != nil {
+		return "", nil, err
+	}
+
+	expression := placeholderPattern.ReplaceAllStringFunc(template.Expression, func(match string) string {
+		return literals[placeholderPattern.FindStringSubmatch(match)[1]]
+	})
+	return expression, supplied, nil
+}
+
+// declaredParams drops stored parameters the template no longer declares.
+func declaredParams(template *models.RuleTemplate, params map[string]interface{}) map[string]interface{} {
+	kept := make(map[string]interface{}, len(params))
+	for _, param := range template.Params {
+		if value, ok := params[param.Name]; ok {
+			kept[param.Name] = value
+		}
+	}
+	return kept
+}
+
+func paramLiteral(paramType string, value interface{}) (string, error) {
+	switch paramType {
+	case models.ParamNumber:
+		switch n := value.(type) {
+		case float64:
+			return formatNumber(n), nil
+		case int:
+			return formatNumber(float64(n)), nil
+		}
+		return "", fmt.Errorf("must be a number")
+	case models.ParamString:
+		str, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("must be a string")
+		}
+		return conditions.Quote(str), nil
+	}
+	return "", fmt.Errorf("unknown parameter type %q", paramType)
+}
+
+func validateTemplate(template *models.RuleTemplate) error {
+	verr := &ValidationError{}
+	if template.Name == "" {
+		verr.add("name", "is required")
+	}
+
+	declared := make(map[string]bool, len(template.Params))
+	sample := make(map[string]interface{}, len(template.Params))
+	for i, param := range template.Params {
+		path := fmt.Sprintf("params[%d]", i)
+		if !paramNamePattern.MatchString(param.Name) {
+			verr.add(path+".name", "invalid parameter name %q", param.Name)
+		} else if declared[param.Name] {
+			verr.add(path+".name", "duplicate parameter %q", param.Name)
+		}
+		declared[param.Name] = true
+
+		switch param.Type {
+		case models.ParamNumber:
+			sample[param.Name] = float64(0)
+		case models.ParamString:
+			sample[param.Name] = ""
+		default:
+			verr.add(path+".type", "must be %q or %q", models.ParamNumber, models.ParamString)
+			continue
+		}
+		if param.Default != nil {
+			if _, err := paramLiteral(param.Type, param.Default); err != nil {
+				verr.add(path+".default", "%v", err)
+			}
+		}
+	}
+
+	for _, match := range placeholderPattern.FindAllStringSubmatch(template.Expression, -1) {
+		if !declared[match[1]] {
+			verr.add("expression", "placeholder ${%s} is not a declared parameter", match[1])
+		}
+	}
+	if len(verr.Problems) > 0 {
+		return verr
+	}
+
+	probe := &models.Rule{Name: template.Name, Mode: models.RuleModeDisabled}
+	if err := applyTemplate(probe, template, sample); err != nil {
+		prefixProblems(verr, "", err)
+	} else if err := ValidateRule(probe); err != nil {
+		prefixProblems(verr, "", err)
+	}
+	return verr.orNil()
+}
+
+func prefixProblems(verr *ValidationError, prefix string, err error) {
+	var inner *ValidationError
+	if !errors.As(err, &inner) {
+		verr.add(prefix, "%v", err)
+		return
+	}
+	for _, problem := range inner.Problems {
+		field := problem.Field
+		if prefix != "" {
+			field = prefix + "." + field
+		}
+		verr.add(field, "%s", problem.Message)
+	}
+}
+
+func templateFromRequest(req *dto.TemplateRequest) *models.RuleTemplate {
+	return &models.RuleTemplate{
+		Name:              req.Name,
+		Description:       req.Description,
+		Params:            req.Params,
+		Expression:        req.Expression,
+		Actions:           req.Actions,
+		Priority:          req.Priority,
+		Final:             req.Final,
+		SingleAction:      req.SingleAction,
+		CooldownSeconds:   req.CooldownSeconds,
+		CooldownPerSymbol: req.CooldownPerSymbol,
+		Escalation:        req.Escalation,
+		ViolationDecay:    req.ViolationDecay,
+		Trigger:           req.Trigger,
+	}
+}
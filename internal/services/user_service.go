@@ -1,6 +1,9 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"log"
 	"sync"
 	"time"
 
@@ -11,14 +14,22 @@ import (
 
 type UserService struct {
 	repo   *repository.RedisRepository
+	clock  *BrokerClock
 	states map[string]*models.UserState
 	mu     sync.RWMutex
+	saves  sync.WaitGroup
+	saveMu sync.Mutex
+	queued map[string][]byte
+	saving map[string]bool
 }
 
-func NewUserService(repo *repository.RedisRepository) *UserService {
+func NewUserService(repo *repository.RedisRepository, clock *BrokerClock) *UserService {
 	return &UserService{
 		repo:   repo,
+		clock:  clock,
 		states: make(map[string]*models.UserState),
+		queued: make(map[string][]byte),
+		saving: make(map[string]bool),
 	}
 }
 
@@ -46,11 +57,14 @@ func (s *UserService) CreateUserState(userID string) *models.UserState {
 		CustomData:   make(map[string]string),
 		LastActivity: time.Now().Unix(),
 	}
+	newState.Mu.Lock()
+	defer newState.Mu.Unlock()
+
 	s.mu.Lock()
 	s.states[userID] = newState
 	s.mu.Unlock()
 
-	go s.repo.SaveUserState(newState)
+	s.persist(newState)
 
 	return newState
 }
@@ -90,32 +104,183 @@ func (s *UserService) UpdateUserState(userID string, req *dto.UpdateUserStateReq
 
 	state.LastActivity = time.Now().Unix()
 
-	go s.repo.SaveUserState(state)
+	s.persist(state)
 
 	return state
 }
 
-func (s *UserService) UpdateUserStateWithEvent(state *models.UserState, event *models.MT5Event) {
+// UpdateUserStateWithEvent applies the event unless the state already holds
+// the event log entry logID, and reports whether it did.
+func (s *UserService) UpdateUserStateWithEvent(state *models.UserState, event *models.MT5Event, logID string) bool {
 	state.Mu.Lock()
 	defer state.Mu.Unlock()
 
-	state.LastActivity = time.Now().Unix()
+	if logID != "" {
+		if !streamIDAfter(logID, state.LastEventID) {
+			return false
+		}
+		state.LastEventID = logID
+	}
+	s.ApplyEvent(state, event, time.Now().Unix())
+
+	s.persist(state)
+	return true
+}
+
+func (s *UserService) ApplyEvent(state *models.UserState, event *models.MT5Event, now int64) {
+	state.LastActivity = now
+
+	eventTime := event.Timestamp
+	if eventTime == 0 {
+		eventTime = now
+	}
+	s.rollDay(state, eventTime)
 
 	switch event.EventType {
-	case "ORDER_OPEN":
+	case models.EventOrderOpen:
 		state.DayVolume += event.Volume
-		state.OpenPositions += 1
-	case "ORDER_CLOSE":
-		state.OpenPositions -= 1
-	case "BALANCE_UPDATE":
+		if _, filled := pendingOrderTypes[event.OrderType]; filled && state.PendingOrders > 0 {
+			state.PendingOrders -= 1
+		}
+		s.applyPositionEvent(state, event, eventTime)
+	case models.EventOrderClose, models.EventOrderModify, models.EventPartialClose:
+		s.applyPositionEvent(state, event, eventTime)
+	case models.EventPendingPlace:
+		state.PendingOrders += 1
+	case models.EventPendingCancel:
+		if state.PendingOrders > 0 {
+			state.PendingOrders -= 1
+		}
+	case models.EventBalanceUpdate:
 		state.Balance = event.Price
-	case "EQUITY_UPDATE":
+		if state.InitialBalance == 0 {
+			state.InitialBalance = event.Price
+		}
+		if state.DayStartBalance == 0 {
+			state.DayStartBalance = event.Price
+		}
+	case models.EventEquityUpdate:
 		state.Equity = event.Price
+		if state.DayStartEquity == 0 {
+			state.DayStartEquity = event.Price
+		}
+		if event.Price > state.EquityHighWater {
+			state.EquityHighWater = event.Price
+		}
+	case models.EventDeposit:
+		s.applyTransfer(state, event.Amount)
+	case models.EventWithdrawal:
+		s.applyTransfer(state, -event.Amount)
+	case models.EventMarginCall:
+		state.MarginLevel = event.MarginLevel
+		state.MarginCalls += 1
+	case models.EventStopOut:
+		state.MarginLevel = event.MarginLevel
+		state.StopOuts += 1
+	case models.EventLogin:
+		state.LoggedIn = true
+		state.LastLoginAt = eventTime
+	case models.EventLogout:
+		state.LoggedIn = false
+	}
+}
+
+// Deposits and withdrawals move every loss reference with the balance so
+// that funding changes are not reported as profit or drawdown.
+func (s *UserService) applyTransfer(state *models.UserState, amount float64) {
+	state.Balance += amount
+	state.Equity += amount
+	state.InitialBalance += amount
+	state.DayStartBalance += amount
+	state.DayStartEquity += amount
+	if state.EquityHighWater > 0 {
+		state.EquityHighWater += amount
 	}
+}
 
-	state.LastActivity = time.Now().Unix()
+func (s *UserService) rollDay(state *models.UserState, now int64) {
+	dayStart := s.clock.DayStart(now)
+	if state.DayStartedAt >= dayStart {
+		return
+	}
+
+	state.DayStartedAt = dayStart
+	state.DayStartBalance = state.Balance
+	state.DayStartEquity = state.Equity
+	state.DayVolume = 0
+}
+
+func (s *UserService) RecordViolation(state *models.UserState, rule *models.Rule, now int64) models.ViolationCounter {
+	state.Mu.Lock()
+	defer state.Mu.Unlock()
+
+	counter := recordViolation(state, rule, now)
+	s.persist(state)
+
+	return counter
+}
+
+func (s *UserService) PeekViolation(state *models.UserState, rule *models.Rule, now int64) models.ViolationCounter {
+	state.Mu.RLock()
+	defer state.Mu.RUnlock()
+
+	return nextViolation(state, rule, now)
+}
+
+// recordViolation must be called with state.Mu held, or on a state no one
+// else can see, such as a backtest's.
+func recordViolation(state *models.UserState, rule *models.Rule, now int64) models.ViolationCounter {
+	next := nextViolation(state, rule, now)
+	if state.RuleViolations == nil {
+		state.RuleViolations = make(map[string]*models.ViolationCounter)
+	}
+	state.RuleViolations[rule.ID] = &next
+	state.ViolationCount++
+	return next
+}
+
+func nextViolation(state *models.UserState, rule *models.Rule, now int64) models.ViolationCounter {
+	next := models.ViolationCounter{Count: 1, LastAt: now}
+	if counter, exists := state.RuleViolations[rule.ID]; exists {
+		next.Count = decayedCount(counter, rule.ViolationDecay, now) + 1
+	}
+	next.Step = escalationStep(rule, next.Count)
+	return next
+}
+
+func decayedCount(counter *models.ViolationCounter, decay, now int64) int {
+	if decay <= 0 || counter.LastAt == 0 {
+		return counter.Count
+	}
+
+	count := counter.Count - int((now-counter.LastAt)/decay)
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+func escalationStep(rule *models.Rule, count int) int {
+	if len(rule.Escalation) == 0 {
+		return 0
+	}
+	if count > len(rule.Escalation) {
+		return len(rule.Escalation)
+	}
+	return count
+}
 
-	go s.repo.SaveUserState(state)
+func (s *UserService) RecordEnforcement(enforcement *models.EnforcementMessage) {
+	if err := s.repo.SaveEnforcement(enforcement); err != nil {
+		log.Printf("Failed to store enforcement for user %s: %v", enforcement.UserId, err)
+	}
+}
+
+func (s *UserService) GetEnforcements(userID string, limit int) ([]*models.EnforcementMessage, error) {
+	if limit <= 0 {
+		limit = 100
+	}
+	return s.repo.GetEnforcements(userID, int64(limit))
 }
 
 func (s *UserService) GetUserCount() int {
@@ -124,15 +289,119 @@ func (s *UserService) GetUserCount() int {
 	return len(s.states)
 }
 
-func (s *UserService) SyncAllStates() {
+// LoadStates loads the stored state of every user the filter accepts that is
+// not in memory yet, so work driven by AllStates also covers users who have
+// not sent an event since the engine started. It returns how many it loaded.
+func (s *UserService) LoadStates(accept func(userID string) bool) (int, error) {
+	ids, err := s.repo.GetUserIDs()
+	if err != nil {
+		return 0, err
+	}
+
+	loaded := 0
+	for _, userID := range ids {
+		if !accept(userID) {
+			continue
+		}
+		s.mu.RLock()
+		_, exists := s.states[userID]
+		s.mu.RUnlock()
+		if exists {
+			continue
+		}
+		if s.GetUserState(userID) != nil {
+			loaded++
+		}
+	}
+	return loaded, nil
+}
+
+func (s *UserService) AllStates() []*models.UserState {
 	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	states := make([]*models.UserState, 0, len(s.states))
 	for _, state := range s.states {
 		states = append(states, state)
 	}
-	s.mu.RUnlock()
+	return states
+}
+
+// persist must be called with state.Mu held. The snapshot is taken under
+// that lock and saves for one user are written in order, latest last.
+func (s *UserService) persist(state *models.UserState) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		log.Printf("Failed to encode state for user %s: %v", state.UserID, err)
+		return
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if s.saving[state.UserID] {
+		s.queued[state.UserID] = data
+		return
+	}
+	s.saving[state.UserID] = true
+	s.saves.Add(1)
+	go s.writeStates(state.UserID, data)
+}
 
-	for _, state := range states {
+func (s *UserService) writeStates(userID string, data []byte) {
+	defer s.saves.Done()
+	for {
+		if err := s.repo.SaveUserStateData(userID, data); err != nil {
+			log.Printf("Failed to save state for user %s: %v", userID, err)
+		}
+
+		s.saveMu.Lock()
+		next, ok := s.queued[userID]
+		if !ok {
+			delete(s.saving, userID)
+			s.saveMu.Unlock()
+			return
+		}
+		delete(s.queued, userID)
+		s.saveMu.Unlock()
+		data = next
+	}
+}
+
+func (s *UserService) Flush(ctx context.Context) (saved, failed int) {
+	pending := make(chan struct{})
+	go func() {
+		s.saves.Wait()
+		close(pending)
+	}()
+	select {
+	case <-pending:
+	case <-ctx.Done():
+		log.Println("Timed out waiting for in-flight user state saves")
+	}
+
+	for _, state := range s.AllStates() {
+		if ctx.Err() != nil {
+			failed++
+			continue
+		}
+
+		state.Mu.RLock()
+		err := s.repo.SaveUserState(state)
+		state.Mu.RUnlock()
+		if err != nil {
+			log.Printf("Failed to flush state for user %s: %v", state.UserID, err)
+			failed++
+			continue
+		}
+		saved++
+	}
+	return saved, failed
+}
+
+func (s *UserService) SyncAllStates() {
+	for _, state := range s.AllStates() {
+		state.Mu.RLock()
 		s.repo.SaveUserState(state)
+		state.Mu.RUnlock()
 	}
 }
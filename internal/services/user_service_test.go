@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func TestViolationsEscalateAndDecay(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	state := e.users.CreateUserState("alice")
+	rule := &models.Rule{ID: "big-trades", Escalation: ladder, ViolationDecay: 60}
+
+	steps := []struct {
+		at        int64
+		wantCount int
+		wantStep  int
+	}{
+		{1000, 1, 1},
+		{1010, 2, 2},
+		{1020, 3, 3},
+		// Past the end of the ladder the last step repeats.
+		{1030, 4, 3},
+		// Two decay periods forgive two violations before this one counts.
+		{1150, 3, 3},
+		// Long enough to forget everything.
+		{2000, 1, 1},
+	}
+	for _, step := range steps {
+		got := e.users.RecordViolation(state, rule, step.at)
+		if got.Count != step.wantCount || got.Step != step.wantStep || got.LastAt != step.at {
+			t.Errorf("violation at %d = %+v, want count %d at step %d", step.at, got, step.wantCount, step.wantStep)
+		}
+	}
+	if state.ViolationCount != len(steps) {
+		t.Errorf("ViolationCount = %d, want %d", state.ViolationCount, len(steps))
+	}
+}
+
+func TestPeekViolationLeavesCountersAlone(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	state := e.users.CreateUserState("alice")
+	rule := &models.Rule{ID: "big-trades", Escalation: ladder}
+
+	e.users.RecordViolation(state, rule, 1000)
+	if peeked := e.users.PeekViolation(state, rule, 1010); peeked.Step != 2 {
+		t.Errorf("PeekViolation step = %d, want 2", peeked.Step)
+	}
+	if counter := state.RuleViolations[rule.ID]; counter.Count != 1 || state.ViolationCount != 1 {
+		t.Errorf("after peeking: counter %+v, ViolationCount %d, want both unchanged at 1", counter, state.ViolationCount)
+	}
+}
+
+func TestActionsForStepFollowsTheLadder(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	plain := &models.Rule{Actions: []models.Action{{Type: models.ActionWarn, Severity: 1}}}
+	laddered := &models.Rule{Actions: plain.Actions, Escalation: ladder}
+
+	cases := []struct {
+		rule *models.Rule
+		step int
+		want string
+	}{
+		{plain, 2, models.ActionWarn},
+		{laddered, 0, models.ActionWarn},
+		{laddered, 2, models.ActionBlockOrder},
+		{laddered, 9, models.ActionDisableTrading},
+	}
+	for _, c := range cases {
+		actions := e.rules.ActionsForStep(c.rule, c.step)
+		if len(actions) != 1 || actions[0].Type != c.want {
+			t.Errorf("ActionsForStep(step %d) = %+v, want %s", c.step, actions, c.want)
+		}
+	}
+}
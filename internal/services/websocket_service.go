@@ -71,19 +71,61 @@ func (s *WebSocketService) SendEnforcement(enforcement *models.EnforcementMessag
 	message := dto.WSMessage{
 		Type: "enforcement",
 		Data: map[string]interface{}{
-			"user_id":   enforcement.UserId,
-			"action":    enforcement.Action,
-			"reason":    enforcement.Reason,
-			"severity":  enforcement.Severity,
-			"timestamp": enforcement.Timestamp,
+			"user_id":         enforcement.UserId,
+			"action":          enforcement.Action,
+			"reason":          enforcement.Reason,
+			"severity":        enforcement.Severity,
+			"timestamp":       enforcement.Timestamp,
+			"escalation_step": enforcement.EscalationStep,
+			"explanation":     enforcement.Explanation,
 		},
 	}
 
 	s.SendMessage(enforcement.UserId, message)
 }
 
+func (s *WebSocketService) SendShadowEnforcement(record *models.ShadowEnforcement) {
+	message := dto.WSMessage{
+		Type: "shadow_enforcement",
+		Data: map[string]interface{}{
+			"rule_id":     record.RuleID,
+			"rule_name":   record.RuleName,
+			"user_id":     record.Enforcement.UserId,
+			"action":      record.Enforcement.Action,
+			"reason":      record.Enforcement.Reason,
+			"severity":    record.Enforcement.Severity,
+			"timestamp":   record.Enforcement.Timestamp,
+			"explanation": record.Enforcement.Explanation,
+		},
+	}
+
+	s.SendMessage(record.Enforcement.UserId, message)
+}
+
 func (s *WebSocketService) GetClientCount() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	return len(s.clients)
 }
+
+func (s *WebSocketService) CloseAll(reason string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, _ := json.Marshal(dto.WSMessage{
+		Type: "goodbye",
+		Data: map[string]interface{}{
+			"reason": reason,
+		},
+	})
+
+	closed := 0
+	for id, conn := range s.clients {
+		conn.WriteMessage(websocket.TextMessage, data)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, reason))
+		conn.Close()
+		delete(s.clients, id)
+		closed++
+	}
+	return closed
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+type windowEntry struct {
+	timestamp int64
+	eventType string
+	symbol    string
+	volume    float64
+}
+
+type WindowAggregate struct {
+	Count int
+	Sum   float64
+}
+
+func (a WindowAggregate) Avg() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+type WindowService struct {
+	windows   map[string][]windowEntry
+	maxEvents int
+	retention int64
+	mu        sync.RWMutex
+}
+
+func NewWindowService(maxEvents int, retention int64) *WindowService {
+	return &WindowService{
+		windows:   make(map[string][]windowEntry),
+		maxEvents: maxEvents,
+		retention: retention,
+	}
+}
+
+func (s *WindowService) Record(event *models.MT5Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := event.Timestamp
+	if now == 0 {
+		now = time.Now().Unix()
+	}
+	entries := append(s.windows[event.UserId], windowEntry{
+		timestamp: now,
+		eventType: event.EventType,
+		symbol:    event.Symbol,
+		volume:    event.Volume,
+	})
+
+	cutoff := now - s.retention
+	drop := 0
+	for drop < len(entries) && entries[drop].timestamp <= cutoff {
+		drop++
+	}
+	if over := len(entries) - drop - s.maxEvents; over > 0 {
+		drop += over
+	}
+	if drop > 0 {
+		entries = append(entries[:0:0], entries[drop:]...)
+	}
+
+	s.windows[event.UserId] = entries
+}
+
+func (s *WindowService) Aggregate(userID, eventType, symbol string, seconds, now int64) WindowAggregate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	agg := WindowAggregate{}
+	cutoff := now - seconds
+	entries := s.windows[userID]
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.timestamp <= cutoff {
+			break
+		}
+		if entry.timestamp > now {
+			continue
+		}
+		if eventType != "*" && entry.eventType != eventType {
+			continue
+		}
+		if symbol != "" && entry.symbol != symbol {
+			continue
+		}
+		agg.Count++
+		agg.Sum += entry.volume
+	}
+	return agg
+}
+
+// Prune drops the buffers of users whose newest entry has fallen out of
+// every window a rule can ask for, and returns how many it dropped.
+func (s *WindowService) Prune(now int64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now - s.retention
+	pruned := 0
+	for userID, entries := range s.windows {
+		if len(entries) == 0 || entries[len(entries)-1].timestamp <= cutoff {
+			delete(s.windows, userID)
+			pruned++
+		}
+	}
+	return pruned
+}
+
+func (s *WindowService) Empty() *WindowService {
+	return NewWindowService(s.maxEvents, s.retention)
+}
+
+func (s *WindowService) Retention() int64 {
+	return s.retention
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/NOTMKW/DLLBEL/internal/models"
+)
+
+func windowEvent(user, eventType, symbol string, volume float64, at int64) *models.MT5Event {
+	return &models.MT5Event{UserId: user, EventType: eventType, Symbol: symbol, Volume: volume, Timestamp: at}
+}
+
+func TestWindowAggregateFiltersByTypeSymbolAndAge(t *testing.T) {
+	s := NewWindowService(100, 3600)
+	s.Record(windowEvent("alice", models.EventOrderOpen, "EURUSD", 1, 1000))
+	s.Record(windowEvent("alice", models.EventOrderOpen, "XAUUSD", 2, 1050))
+	s.Record(windowEvent("alice", models.EventOrderClose, "EURUSD", 4, 1090))
+	s.Record(windowEvent("bob", models.EventOrderOpen, "EURUSD", 8, 1090))
+
+	cases := []struct {
+		name      string
+		eventType string
+		symbol    string
+		seconds   int64
+		want      WindowAggregate
+	}{
+		{"every type", "*", "", 300, WindowAggregate{Count: 3, Sum: 7}},
+		{"one type", models.EventOrderOpen, "", 300, WindowAggregate{Count: 2, Sum: 3}},
+		{"one symbol", "*", "EURUSD", 300, WindowAggregate{Count: 2, Sum: 5}},
+		{"entry on the cutoff excluded", "*", "", 100, WindowAggregate{Count: 2, Sum: 6}},
+	}
+	for _, c := range cases {
+		if got := s.Aggregate("alice", c.eventType, c.symbol, c.seconds, 1100); got != c.want {
+			t.Errorf("%s: Aggregate = %+v, want %+v", c.name, got, c.want)
+		}
+	}
+
+	// Entries after the evaluated moment do not count towards it.
+	if got := s.Aggregate("alice", "*", "", 300, 1060); got.Count != 2 || got.Avg() != 1.5 {
+		t.Errorf("Aggregate at 1060 = %+v (avg %v), want 2 entries averaging 1.5", got, got.Avg())
+	}
+}
+
+func TestWindowRecordBoundsEntries(t *testing.T) {
+	s := NewWindowService(2, 60)
+	for i := int64(0); i < 3; i++ {
+		s.Record(windowEvent("alice", models.EventOrderOpen, "EURUSD", 1, 1000+i))
+	}
+	if got := s.Aggregate("alice", "*", "", 60, 1010); got.Count != 2 {
+		t.Errorf("Count = %d after exceeding maxEvents, want 2", got.Count)
+	}
+
+	s.Record(windowEvent("alice", models.EventOrderOpen, "EURUSD", 1, 1100))
+	if got := s.Aggregate("alice", "*", "", 60, 1100); got.Count != 1 {
+		t.Errorf("Count = %d after retention passed, want 1", got.Count)
+	}
+}
+
+func TestWindowRecordStampsEventsWithoutTimestamp(t *testing.T) {
+	s := NewWindowService(100, 60)
+	s.Record(windowEvent("alice", models.EventOrderOpen, "EURUSD", 1, 0))
+
+	if got := s.Aggregate("alice", "*", "", 60, time.Now().Unix()); got.Count != 1 {
+		t.Errorf("Count = %d, want the unstamped event inside the current window", got.Count)
+	}
+}
+
+func TestWindowPruneDropsIdleUsers(t *testing.T) {
+	s := NewWindowService(100, 60)
+	s.Record(windowEvent("idle", models.EventOrderOpen, "EURUSD", 1, 1000))
+	s.Record(windowEvent("active", models.EventOrderOpen, "EURUSD", 1, 1000))
+	s.Record(windowEvent("active", models.EventOrderOpen, "EURUSD", 1, 1050))
+
+	if pruned := s.Prune(1100); pruned != 1 {
+		t.Errorf("Prune = %d, want 1", pruned)
+	}
+	if _, ok := s.windows["idle"]; ok {
+		t.Error("idle user's window survived pruning")
+	}
+	if got := s.Aggregate("active", "*", "", 60, 1100); got.Count != 1 {
+		t.Errorf("active user's Count = %d, want 1", got.Count)
+	}
+}
+
+func TestWindowRulesUseEvaluationTimeForUnstampedEvents(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	now := time.Now().Unix()
+	for i := int64(0); i < 3; i++ {
+		e.windows.Record(windowEvent("alice", models.EventOrderOpen, "EURUSD", 1, now-i))
+	}
+
+	rule := &models.Rule{ID: "burst", Expression: "window_count('ORDER_OPEN', 60) >= 3"}
+	event := &models.MT5Event{UserId: "alice", EventType: models.EventScheduleTick}
+	if !e.rules.EvaluateRule(rule, event, e.users.CreateUserState("alice")) {
+		t.Error("window rule missed recent events when evaluated for an event without a timestamp")
+	}
+}
+
+func TestAcceptStampsEventsWithoutTimestamp(t *testing.T) {
+	e := newTestEngine(t, 0, 1, 8, 1)
+	user := userFor(e.history, true)
+	event := testOpen(user, 1)
+	event.Timestamp = 0
+
+	before := time.Now().Unix()
+	if !e.events.TrySubmit("dll-1", event) {
+		t.Fatal("TrySubmit refused")
+	}
+	if event.Timestamp < before {
+		t.Errorf("Timestamp = %d, want the time the event was accepted", event.Timestamp)
+	}
+}
@@ -0,0 +1,75 @@
+package evaluators
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	Register("max_volume", NumberLimit("event.volume", func(in *Input) (float64, bool) {
+		return in.Event.Volume, true
+	}))
+	Register("max_day_volume", NumberLimit("state.day_volume", func(in *Input) (float64, bool) {
+		return in.State.DayVolume, true
+	}))
+	Register("max_positions", ParseFunc(parseMaxPositions))
+	Register("symbol_restricted", ParseFunc(parseSymbolRestricted))
+}
+
+func NumberLimit(subject string, observe func(in *Input) (float64, bool)) Evaluator {
+	return ParseFunc(func(param string) (Condition, error) {
+		limit, err := strconv.ParseFloat(strings.TrimSpace(param), 64)
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("must be a non-negative number, got %q", param)
+		}
+
+		return ConditionFunc(func(in *Input) Result {
+			observed, ok := observe(in)
+			if !ok {
+				return Result{Subject: subject, Operator: ">", Threshold: limit}
+			}
+			return Result{
+				Matched:   observed > limit,
+				Subject:   subject,
+				Operator:  ">",
+				Observed:  observed,
+				Threshold: limit,
+			}
+		}), nil
+	})
+}
+
+func parseMaxPositions(param string) (Condition, error) {
+	limit, err := strconv.Atoi(strings.TrimSpace(param))
+	if err != nil || limit < 0 {
+		return nil, fmt.Errorf("must be a non-negative integer, got %q", param)
+	}
+
+	return ConditionFunc(func(in *Input) Result {
+		return Result{
+			Matched:   in.State.OpenPositions > limit,
+			Subject:   "state.open_positions",
+			Operator:  ">",
+			Observed:  in.State.OpenPositions,
+			Threshold: limit,
+		}
+	}), nil
+}
+
+func parseSymbolRestricted(param string) (Condition, error) {
+	symbol := strings.TrimSpace(param)
+	if symbol == "" {
+		return nil, fmt.Errorf("symbol must not be empty")
+	}
+
+	return ConditionFunc(func(in *Input) Result {
+		return Result{
+			Matched:   in.Event.Symbol == symbol,
+			Subject:   "event.symbol",
+			Operator:  "==",
+			Observed:  in.Event.Symbol,
+			Threshold: symbol,
+		}
+	}), nil
+}
@@ -0,0 +1,139 @@
+// Package evaluators is the extension point for custom rule conditions. A
+// detector package calls Register from its init function; it then works as
+// a key in a rule's conditions map and as a call such as name("param") in
+// a rule expression. Blank-import the package from the service's main.
+package evaluators
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Event is the incoming MT5 event a condition is evaluated against.
+type Event struct {
+	ID          string
+	UserID      string
+	Type        string
+	Symbol      string
+	Volume      float64
+	Price       float64
+	Timestamp   int64
+	Ticket      int64
+	PositionID  int64
+	Side        string
+	OrderType   string
+	StopLoss    float64
+	TakeProfit  float64
+	Magic       int64
+	Comment     string
+	Amount      float64
+	MarginLevel float64
+}
+
+type Position struct {
+	Ticket     int64
+	Symbol     string
+	Side       string
+	Volume     float64
+	OpenPrice  float64
+	OpenTime   int64
+	StopLoss   float64
+	TakeProfit float64
+}
+
+// State is a read-only view of the user's state; CustomData is shared with
+// the engine and must not be modified.
+type State struct {
+	UserID          string
+	Balance         float64
+	Equity          float64
+	OpenPositions   int
+	PendingOrders   int
+	DayVolume       float64
+	RiskLevel       string
+	ViolationCount  int
+	InitialBalance  float64
+	DayStartBalance float64
+	DayStartEquity  float64
+	EquityHighWater float64
+	MarginLevel     float64
+	LoggedIn        bool
+	CustomData      map[string]string
+	Positions       []Position
+}
+
+type Input struct {
+	Event *Event
+	State *State
+	Now   int64
+	// Field resolves any rule expression field, such as "state.daily_loss",
+	// to a float64, string or bool; ok is false when it is unknown or null.
+	Field func(name string) (value interface{}, ok bool)
+}
+
+type Result struct {
+	Matched   bool
+	Subject   string
+	Operator  string
+	Observed  interface{}
+	Threshold interface{}
+}
+
+type Condition interface {
+	Evaluate(in *Input) Result
+}
+
+type Evaluator interface {
+	Parse(param string) (Condition, error)
+}
+
+type ConditionFunc func(in *Input) Result
+
+func (f ConditionFunc) Evaluate(in *Input) Result {
+	return f(in)
+}
+
+type ParseFunc func(param string) (Condition, error)
+
+func (f ParseFunc) Parse(param string) (Condition, error) {
+	return f(param)
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Evaluator{}
+)
+
+func Register(name string, evaluator Evaluator) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if evaluator == nil {
+		panic("evaluators: Register evaluator is nil")
+	}
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("evaluators: Register called twice for %q", name))
+	}
+	registry[name] = evaluator
+}
+
+func Lookup(name string) (Evaluator, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	evaluator, ok := registry[name]
+	return evaluator, ok
+}
+
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}